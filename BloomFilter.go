@@ -0,0 +1,175 @@
+package tablecache
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// max bits of a redis string, 512MB
+const maxBloomBits = uint64(1) << 32
+
+// BloomFilter bloom filter on redis bitmap, no RedisBloom module required.
+// A filter which is not built yet (key not exists) might contain everything.
+type BloomFilter struct {
+	redisClient *redis.Client
+	key         string
+	bits        uint64
+	hashes      int
+}
+
+// NewBloomFilter sized by expected number of items and false positive rate, eg. NewBloomFilter(rc, "user/bloom", 1000000, 0.001)
+func NewBloomFilter(redisClient *redis.Client, key string, expected uint64, falsePositiveRate float64) *BloomFilter {
+	if expected == 0 {
+		expected = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.01
+	}
+	bits := uint64(math.Ceil(-float64(expected) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	if bits > maxBloomBits {
+		bits = maxBloomBits
+	}
+	hashes := int(math.Round(float64(bits) / float64(expected) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &BloomFilter{
+		redisClient: redisClient,
+		key:         key,
+		bits:        bits,
+		hashes:      hashes,
+	}
+}
+
+func (s *BloomFilter) GetKey() string {
+	return s.key
+}
+
+// offsets double hashing: h1 + i*h2
+func (s *BloomFilter) offsets(value string) []int64 {
+	h := fnv.New128a()
+	h.Write([]byte(value))
+	sum := h.Sum(nil)
+	h1 := binary.BigEndian.Uint64(sum[:8])
+	h2 := binary.BigEndian.Uint64(sum[8:]) | 1
+	r := make([]int64, s.hashes)
+	for i := 0; i < s.hashes; i++ {
+		r[i] = int64((h1 + uint64(i)*h2) % s.bits)
+	}
+	return r
+}
+
+// set bits of live filter if it is built, and staging filter if it is being built
+var bloomAddScript = redis.NewScript(`
+local built = redis.call('EXISTS', KEYS[1]) == 1
+local building = redis.call('EXISTS', KEYS[2]) == 1
+for _, offset in ipairs(ARGV) do
+	if built then
+		redis.call('SETBIT', KEYS[1], offset, 1)
+	end
+	if building then
+		redis.call('SETBIT', KEYS[2], offset, 1)
+	end
+end
+return 1
+`)
+
+// Add values into filter
+func (s *BloomFilter) Add(ctx context.Context, values ...string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	var offsets []interface{}
+	for _, v := range values {
+		for _, offset := range s.offsets(v) {
+			offsets = append(offsets, offset)
+		}
+	}
+	return bloomAddScript.Run(ctx, s.redisClient, []string{s.key, s.stagingKey()}, offsets...).Err()
+}
+
+func (s *BloomFilter) stagingKey() string {
+	return s.key + "/building"
+}
+
+// MightContain false means value is definitely not in filter
+func (s *BloomFilter) MightContain(ctx context.Context, value string) (bool, error) {
+	r, err := s.MightContainMany(ctx, []string{value})
+	if err != nil {
+		return true, err
+	}
+	return r[0], nil
+}
+
+// MightContainMany check values in one round trip. all true if filter is not built
+func (s *BloomFilter) MightContainMany(ctx context.Context, values []string) ([]bool, error) {
+	r := make([]bool, len(values))
+	for i := range r {
+		r[i] = true
+	}
+	if len(values) == 0 {
+		return r, nil
+	}
+	var exists *redis.IntCmd
+	bits := make([][]*redis.IntCmd, len(values))
+	_, err := s.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		exists = pipe.Exists(ctx, s.key)
+		for i, v := range values {
+			for _, offset := range s.offsets(v) {
+				bits[i] = append(bits[i], pipe.GetBit(ctx, s.key, offset))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return r, err
+	}
+	if exists.Val() == 0 {
+		return r, nil
+	}
+	for i := range values {
+		for _, bit := range bits[i] {
+			if bit.Val() == 0 {
+				r[i] = false
+				break
+			}
+		}
+	}
+	return r, nil
+}
+
+// Exists whether the filter has been built
+func (s *BloomFilter) Exists(ctx context.Context) (bool, error) {
+	c, err := s.redisClient.Exists(ctx, s.key).Result()
+	return c > 0, err
+}
+
+// NewStaging create an empty filter of same size in a staging key. add all values to it, then Publish it
+func (s *BloomFilter) NewStaging(ctx context.Context) (*BloomFilter, error) {
+	r := &BloomFilter{
+		redisClient: s.redisClient,
+		key:         s.stagingKey(),
+		bits:        s.bits,
+		hashes:      s.hashes,
+	}
+	err := s.redisClient.Del(ctx, r.key).Err()
+	if err != nil {
+		return nil, err
+	}
+	// make sure staging key exists even if no value added
+	err = s.redisClient.SetBit(ctx, r.key, 0, 0).Err()
+	return r, err
+}
+
+// Publish replace this filter by staging filter atomically
+func (s *BloomFilter) Publish(ctx context.Context, staging *BloomFilter) error {
+	return s.redisClient.Rename(ctx, staging.key, s.key).Err()
+}
+
+func (s *BloomFilter) Clear(ctx context.Context) error {
+	return s.redisClient.Del(ctx, s.key).Err()
+}
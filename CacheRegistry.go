@@ -0,0 +1,300 @@
+package tablecache
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm/schema"
+)
+
+// CacheRegistry link TableCaches with FK tags of DDL, so that deleting or re-keying parent rows invalidates cached child rows
+// which the database cascades to. eg.
+//	registry, err := NewCacheRegistry(ddl)
+//	registry.Register(users, projects)
+type CacheRegistry struct {
+	ddl       *DDL
+	cacheUtil *CacheUtil
+	mu        sync.RWMutex
+	caches    map[string]*TableCache // lower struct name: cache
+	refs      map[string][]fkRef     // lower parent struct name: refs of children
+}
+
+// fkRef child.fields -> parent.parentFields
+type fkRef struct {
+	child        *schema.Schema
+	fields       []*schema.Field
+	parentFields []string
+	parentIsPK   bool
+	onDelete     FKAction
+	onUpdate     FKAction
+}
+
+// key stringified field values of record, eg. 1/a. ok is false if any is null
+func (s fkRef) key(cacheUtil *CacheUtil, record interface{}, fields []string) (string, []interface{}, bool) {
+	values := make([]interface{}, len(fields))
+	strs := make([]string, len(fields))
+	for i, f := range fields {
+		values[i] = cacheUtil.GetFieldValue(record, f)
+		strs[i] = cacheUtil.StringifyDeref(values[i])
+		if strs[i] == NullStr {
+			return "", nil, false
+		}
+	}
+	return strings.Join(strs, "/"), values, true
+}
+
+func (s fkRef) childFields() []string {
+	r := make([]string, len(s.fields))
+	for i, f := range s.fields {
+		r[i] = f.Name
+	}
+	return r
+}
+
+// cascadeEffect child rows which the database changes or deletes because of a parent write
+type cascadeEffect struct {
+	ref      fkRef
+	cache    *TableCache // nil if child table is not cached
+	rows     []interface{}
+	parents  []string // referenced parent value of each row
+	deleting bool     // parent rows are deleted
+}
+
+// NewCacheRegistry tables with FK tags should have been added to ddl by AddTables. invalid FK tags are errors
+func NewCacheRegistry(ddl *DDL) (*CacheRegistry, error) {
+	r := &CacheRegistry{
+		ddl:       ddl,
+		cacheUtil: &CacheUtil{},
+		caches:    make(map[string]*TableCache),
+	}
+	refs, err := r.parseRefs()
+	if err != nil {
+		return nil, fmt.Errorf("cache registry: %w", err)
+	}
+	r.refs = refs
+	return r, nil
+}
+
+// parseRefs foreign keys declared by FK tags of all tables in ddl, with default actions of ddl
+func (s *CacheRegistry) parseRefs() (map[string][]fkRef, error) {
+	fks, err := s.ddl.DeclaredFKs()
+	if err != nil {
+		return nil, err
+	}
+	r := make(map[string][]fkRef)
+	for _, fk := range fks {
+		child := s.ddl.GetSchemaByStructName(fk.Struct)
+		dst := s.ddl.GetSchemaByStructName(fk.RefStruct)
+		ref := fkRef{
+			child:        child,
+			parentFields: fk.RefFields,
+			parentIsPK:   len(fk.RefFields) == len(dst.PrimaryFields),
+			onDelete:     fk.OnDelete,
+			onUpdate:     fk.OnUpdate,
+		}
+		for i, f := range fk.Fields {
+			ref.fields = append(ref.fields, child.LookUpField(f))
+			if ref.parentIsPK && dst.PrimaryFields[i].Name != fk.RefFields[i] {
+				ref.parentIsPK = false
+			}
+		}
+		parent := strings.ToLower(dst.Name)
+		r[parent] = append(r[parent], ref)
+	}
+	return r, nil
+}
+
+// Register caches, their writes will invalidate cached children
+func (s *CacheRegistry) Register(caches ...*TableCache) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range caches {
+		s.caches[strings.ToLower(c.structName)] = c
+		c.registry = s
+	}
+}
+
+func (s *CacheRegistry) getCache(structName string) *TableCache {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.caches[strings.ToLower(structName)]
+}
+
+// collect child rows affected by writing parent rows, before the write. deleting: parent rows are being deleted,
+// else their referenced fields may change. CASCADE deletes are followed down to grandchildren
+func (s *CacheRegistry) collect(parentName string, parents []interface{}, deleting bool, seen map[string]bool) ([]*cascadeEffect, error) {
+	var r []*cascadeEffect
+	for _, ref := range s.refs[strings.ToLower(parentName)] {
+		action := ref.onUpdate
+		if deleting {
+			action = ref.onDelete
+		}
+		if action != FKCascade && action != FKSetNull {
+			continue
+		}
+		// primary keys are not changed by TableCache writes
+		if !deleting && ref.parentIsPK {
+			continue
+		}
+		valueSet := make(map[string]bool, len(parents))
+		var values []interface{}
+		for _, p := range parents {
+			k, v, ok := ref.key(s.cacheUtil, p, ref.parentFields)
+			if ok && !valueSet[k] {
+				valueSet[k] = true
+				if len(v) == 1 {
+					values = append(values, v[0])
+				} else {
+					values = append(values, v)
+				}
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		children := reflect.New(reflect.SliceOf(ref.child.ModelType)).Interface()
+		columns := make([]string, len(ref.fields))
+		for i, f := range ref.fields {
+			columns[i] = f.DBName
+		}
+		cache := s.getCache(ref.child.Name)
+		// composite foreign keys go by row values, eg. (a, b) IN ((1, 2), (3, 4))
+		err := s.ddl.db.Select(s.collectColumns(ref, cache)).Where("("+strings.Join(columns, ", ")+") IN ?", values).Find(children).Error
+		if err != nil {
+			return nil, err
+		}
+		effect := &cascadeEffect{
+			ref:      ref,
+			cache:    cache,
+			deleting: deleting,
+		}
+		pk := ref.child.PrimaryFields[0].Name
+		for _, row := range itemRefs(children) {
+			// self referencing tables
+			key := ref.child.Name + "/" + s.cacheUtil.StringifyDeref(s.cacheUtil.GetFieldValue(row, pk))
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			effect.rows = append(effect.rows, row)
+			parentKey, _, _ := ref.key(s.cacheUtil, row, ref.childFields())
+			effect.parents = append(effect.parents, parentKey)
+		}
+		if len(effect.rows) == 0 {
+			continue
+		}
+		r = append(r, effect)
+		if deleting && action == FKCascade {
+			sub, err := s.collect(ref.child.Name, effect.rows, true, seen)
+			if err != nil {
+				return nil, err
+			}
+			r = append(r, sub...)
+		}
+	}
+	return r, nil
+}
+
+// collectColumns columns of child rows which collect needs: primary key, foreign key, fields referenced by grandchildren
+// and fields of indexes of the child cache, whose keys are invalidated by old values
+func (s *CacheRegistry) collectColumns(ref fkRef, cache *TableCache) []string {
+	var r []string
+	seen := make(map[string]bool)
+	add := func(f *schema.Field) {
+		if f != nil && f.DBName != "" && !seen[f.DBName] {
+			seen[f.DBName] = true
+			r = append(r, f.DBName)
+		}
+	}
+	for _, f := range ref.child.PrimaryFields {
+		add(f)
+	}
+	for _, f := range ref.fields {
+		add(f)
+	}
+	for _, sub := range s.refs[strings.ToLower(ref.child.Name)] {
+		for _, name := range sub.parentFields {
+			add(ref.child.LookUpField(name))
+		}
+	}
+	if cache != nil {
+		for _, idx := range cache.Indexes {
+			for _, name := range idx.Fields {
+				add(ref.child.LookUpField(name))
+			}
+		}
+	}
+	return r
+}
+
+// apply invalidate cached child rows after the parent write. changed: referenced parent values which changed, nil for deletes
+func (s *CacheRegistry) apply(effects []*cascadeEffect, changed func(ref fkRef) map[string]bool) error {
+	var errs MultiError
+	for _, effect := range effects {
+		if effect.cache == nil {
+			continue
+		}
+		rows := effect.rows
+		if !effect.deleting {
+			values := changed(effect.ref)
+			rows = nil
+			for i, row := range effect.rows {
+				if values[effect.parents[i]] {
+					rows = append(rows, row)
+				}
+			}
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		// re-read children, SET NULL and ON UPDATE CASCADE changed them, CASCADE deleted them
+		list, err := effect.cache.fetchIn(effect.cache.idField, effect.cache.idsOf(rows))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := effect.cache.invalidate(itemRefs(list), rows); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// cascadeBefore collect children affected by writing olds, nil if no registry
+func (s *TableCache) cascadeBefore(olds []interface{}, deleting bool) ([]*cascadeEffect, error) {
+	if s.registry == nil || len(olds) == 0 {
+		return nil, nil
+	}
+	return s.registry.collect(s.structName, olds, deleting, make(map[string]bool))
+}
+
+// cascadeAfter invalidate children collected by cascadeBefore. news are parent rows after writing, nil for deletes
+func (s *TableCache) cascadeAfter(effects []*cascadeEffect, olds []interface{}, news []interface{}) error {
+	if len(effects) == 0 {
+		return nil
+	}
+	newByID := make(map[string]interface{}, len(news))
+	for _, v := range news {
+		newByID[s.cacheUtil.Stringify(s.GetID(v))] = v
+	}
+	return s.registry.apply(effects, func(ref fkRef) map[string]bool {
+		r := make(map[string]bool)
+		for _, old := range olds {
+			oldKey, _, ok := ref.key(s.cacheUtil, old, ref.parentFields)
+			if !ok {
+				continue
+			}
+			fresh, ok := newByID[s.cacheUtil.Stringify(s.GetID(old))]
+			if !ok {
+				r[oldKey] = true
+				continue
+			}
+			if freshKey, _, _ := ref.key(s.cacheUtil, fresh, ref.parentFields); freshKey != oldKey {
+				r[oldKey] = true
+			}
+		}
+		return r
+	})
+}
@@ -1,9 +1,9 @@
 package tablecache
 
 import (
-	"errors"
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 
@@ -26,6 +26,8 @@ type DDL struct {
 	cacheStore      sync.Map // struct Type : Schema
 	DefaultOnDelete FKAction
 	DefaultOnUpdate FKAction
+	AlterChanged    bool // re-create existing foreign keys whose actions differ from tags, else keep them
+	script          *DDLScript
 }
 
 func NewDDL(db *gorm.DB) *DDL {
@@ -33,43 +35,75 @@ func NewDDL(db *gorm.DB) *DDL {
 		db:              db,
 		DefaultOnDelete: FKCascade,
 		DefaultOnUpdate: FKCascade,
+		AlterChanged:    true,
 	}
 }
 
-func (s *DDL) AddTables(tables ...interface{}) {
+//AddTables parse tables and check that FK tags reference added structs. add referenced tables in the same or an earlier call
+func (s *DDL) AddTables(tables ...interface{}) error {
+	var errs MultiError
 	for _, v := range tables {
-		schema.Parse(v, &s.cacheStore, s.db.NamingStrategy)
+		if _, err := schema.Parse(v, &s.cacheStore, s.db.NamingStrategy); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if _, err := s.DeclaredFKs(); err != nil {
+		errs = append(errs, err)
 	}
+	return errs.ErrorOrNil()
 }
 func (s *DDL) Range(f func(structType reflect.Type, tableSchema *schema.Schema) bool) {
 	s.cacheStore.Range(func(key, value interface{}) bool {
 		return f(key.(reflect.Type), value.(*schema.Schema))
 	})
 }
-func (s *DDL) AddFK(table, target interface{}, fk string) {
+func (s *DDL) AddFK(table, target interface{}, fk string) error {
 	srcSch := s.GetSchema(table)
 	dstSch := s.GetSchema(target)
-	s.AddForeignKey(srcSch.Table, fk, dstSch.Table, dstSch.PrimaryFieldDBNames[0], FKRestrict, FKCascade)
+	return s.AddForeignKey(srcSch.Table, fk, dstSch.Table, dstSch.PrimaryFieldDBNames[0], FKRestrict, FKCascade)
 }
 func (s *DDL) MakeFKName(table, fkey, target, targetCol string) string {
 	return fmt.Sprintf("fk_%s_%s", table, fkey)
 }
 
-func (s *DDL) AddForeignKey(table, fkey, target, targetCol string, onDelete, onUpdate FKAction) {
-	fkName := s.MakeFKName(table, fkey, target, targetCol)
-	args := []interface{}{table, fkName, fkey, target, targetCol}
-	tpl := "ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)"
-	if onUpdate != FKEmpty {
-		tpl += " ON UPDATE %s"
-		args = append(args, onUpdate)
+//AddForeignKey add foreign key if not exists. an existing one with different actions is re-created if AlterChanged
+func (s *DDL) AddForeignKey(table, fkey, target, targetCol string, onDelete, onUpdate FKAction) error {
+	return s.ensureForeignKey(ForeignKey{
+		Name:       s.MakeFKName(table, fkey, target, targetCol),
+		Table:      table,
+		Columns:    []string{fkey},
+		RefTable:   target,
+		RefColumns: []string{targetCol},
+		OnDelete:   onDelete,
+		OnUpdate:   onUpdate,
+	})
+}
+
+// sameFK same target and actions
+func (s *DDL) sameFK(a, b ForeignKey) bool {
+	dialect := s.Dialect()
+	return a.SameTarget(b) && normalizeAction(dialect, a.OnDelete) == normalizeAction(dialect, b.OnDelete) &&
+		normalizeAction(dialect, a.OnUpdate) == normalizeAction(dialect, b.OnUpdate)
+}
+
+func (s *DDL) ensureForeignKey(fk ForeignKey) error {
+	existing, err := s.ForeignKeys(fk.Table)
+	if err != nil {
+		return err
 	}
-	if onDelete != FKEmpty {
-		tpl += " ON DELETE %s"
-		args = append(args, onDelete)
+	for _, e := range existing {
+		if !e.SameName(fk) && !e.SameTarget(fk) {
+			continue
+		}
+		if s.sameFK(e, fk) {
+			return nil
+		}
+		if !s.AlterChanged {
+			return nil
+		}
+		return s.change(fk.Table, []ForeignKey{e}, []ForeignKey{fk})
 	}
-	ddl := fmt.Sprintf(tpl, args...)
-	tx := s.db.Exec(ddl)
-	fmt.Println(tx.Error)
+	return s.change(fk.Table, nil, []ForeignKey{fk})
 }
 
 // func (s *DDL) GetTableName(tableStruct interface{}) string {
@@ -83,40 +117,146 @@ func (s *DDL) AddForeignKey(table, fkey, target, targetCol string, onDelete, onU
 // 	return stmt.Schema.PrimaryFieldDBNames[0]
 // }
 
-func (s *DDL) AddFKs(table interface{}) {
-	// stmt := &gorm.Statement{DB: s.db}
-	sch, err := schema.Parse(table, &s.cacheStore, s.db.NamingStrategy)
-	fmt.Println(sch, err)
-	for _, f := range sch.Fields {
-		fmt.Println(f.TagSettings)
+//AddFKs parse table, so that MakeFKs adds foreign keys of its FK tags
+func (s *DDL) AddFKs(table interface{}) error {
+	if _, err := schema.Parse(table, &s.cacheStore, s.db.NamingStrategy); err != nil {
+		return fmt.Errorf("parse %T: %w", table, err)
 	}
+	return nil
+}
 
+//MakeFKs add foreign keys declared by FK tags of all tables, existing ones are skipped or altered.
+// foreign keys referencing columns without an unique index are reported as ErrRefNotUnique and not made.
+// CHECK constraints of check tags are added last
+func (s *DDL) MakeFKs() error {
+	fks, err := s.DeclaredFKs()
+	var errs MultiError
+	if err != nil {
+		errs = append(errs, err)
+	}
+	fks, err = s.checkRefUniques(fks)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	plan, err := s.plan(fks, false)
+	if err != nil {
+		return append(errs, err)
+	}
+	if !s.AlterChanged {
+		ops := plan.Ops[:0]
+		for _, op := range plan.Ops {
+			if op.Kind != PlanReplace {
+				ops = append(ops, op)
+			}
+		}
+		plan.Ops = ops
+	}
+	if err := s.Apply(plan); err != nil {
+		errs = append(errs, err)
+	}
+	if err := s.ensureChecks(s.DeclaredChecks()); err != nil {
+		errs = append(errs, err)
+	}
+	return errs.ErrorOrNil()
 }
-func (s *DDL) MakeFKs() {
+
+//DeclaredFKs foreign keys declared by FK tags of all tables, with default actions. fields whose tags have the same name
+// form one composite foreign key, in field order. invalid tags are reported as errors
+func (s *DDL) DeclaredFKs() ([]ForeignKey, error) {
+	var r []ForeignKey
+	var errs MultiError
 	s.Range(func(structType reflect.Type, src *schema.Schema) bool {
+		var fks []ForeignKey
+		byName := make(map[string]int)
 		for _, f := range src.Fields {
-			// fmt.Println(f.TagSettings)
-			if v, ok := f.TagSettings["FK"]; ok {
-				fkInfo := s.ParseFKInfo(v)
-				dst := s.GetSchemaByStructName(fkInfo.StructName)
-				if dst == nil {
-					info := fmt.Sprintf("Make FK error. %s.%s can not ref struct %s\n", src.Name, f.Name, fkInfo.StructName)
-					fmt.Println(info)
-					panic(errors.New(info))
-
-				}
-				if fkInfo.OnDelete == "" {
-					fkInfo.OnDelete = s.DefaultOnDelete
+			v, ok := f.TagSettings["FK"]
+			if !ok {
+				continue
+			}
+			fkInfo, err := s.ParseFKInfo(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("make FK error. %s.%s: %w", src.Name, f.Name, err))
+				continue
+			}
+			dst := s.GetSchemaByStructName(fkInfo.StructName)
+			if dst == nil {
+				errs = append(errs, fmt.Errorf("make FK error. %s.%s can not ref struct %s", src.Name, f.Name, fkInfo.StructName))
+				continue
+			}
+			i, ok := byName[strings.ToLower(fkInfo.Name)]
+			if fkInfo.Name == "" || !ok {
+				i = len(fks)
+				fks = append(fks, ForeignKey{
+					Struct:    src.Name,
+					Name:      fkInfo.Name,
+					Table:     src.Table,
+					RefStruct: dst.Name,
+					RefTable:  dst.Table,
+					OnDelete:  fkInfo.OnDelete,
+					OnUpdate:  fkInfo.OnUpdate,
+				})
+				if fkInfo.Name != "" {
+					byName[strings.ToLower(fkInfo.Name)] = i
 				}
-				if fkInfo.OnUpdate == "" {
-					fkInfo.OnUpdate = s.DefaultOnUpdate
+			}
+			fk := &fks[i]
+			if fk.RefStruct != dst.Name {
+				errs = append(errs, fmt.Errorf("make FK error. %s.%s: %s refs both %s and %s", src.Name, f.Name, fk.Name, fk.RefStruct, dst.Name))
+				continue
+			}
+			if fkInfo.OnDelete != "" && fk.OnDelete != "" && fkInfo.OnDelete != fk.OnDelete ||
+				fkInfo.OnUpdate != "" && fk.OnUpdate != "" && fkInfo.OnUpdate != fk.OnUpdate {
+				errs = append(errs, fmt.Errorf("make FK error. %s.%s: actions of %s differ between fields", src.Name, f.Name, fk.Name))
+				continue
+			}
+			if fk.OnDelete == "" {
+				fk.OnDelete = fkInfo.OnDelete
+			}
+			if fk.OnUpdate == "" {
+				fk.OnUpdate = fkInfo.OnUpdate
+			}
+			var refField *schema.Field
+			if fkInfo.RefField == "" {
+				// composite foreign keys without ref fields go to primary keys in order
+				if len(fk.Fields) < len(dst.PrimaryFields) {
+					refField = dst.PrimaryFields[len(fk.Fields)]
 				}
-				fmt.Printf("Make FK: %s.%s -> %s.%s on delete %s on update %s\n", src.Table, f.DBName, dst.Table, dst.PrimaryFieldDBNames[0], fkInfo.OnDelete, fkInfo.OnUpdate)
-				s.AddForeignKey(src.Table, f.DBName, dst.Table, dst.PrimaryFieldDBNames[0], fkInfo.OnDelete, fkInfo.OnUpdate)
+			} else {
+				refField = dst.LookUpField(fkInfo.RefField)
+			}
+			if refField == nil || refField.DBName == "" {
+				errs = append(errs, fmt.Errorf("make FK error. %s.%s can not ref field %s.%s", src.Name, f.Name, dst.Name, fkInfo.RefField))
+				continue
+			}
+			fk.Fields = append(fk.Fields, f.Name)
+			fk.Columns = append(fk.Columns, f.DBName)
+			fk.RefFields = append(fk.RefFields, refField.Name)
+			fk.RefColumns = append(fk.RefColumns, refField.DBName)
+		}
+		for _, fk := range fks {
+			if len(fk.Columns) == 0 {
+				continue
 			}
+			if fk.Name == "" {
+				fk.Name = s.MakeFKName(fk.Table, fk.Columns[0], fk.RefTable, fk.RefColumns[0])
+			}
+			if fk.OnDelete == "" {
+				fk.OnDelete = s.DefaultOnDelete
+			}
+			if fk.OnUpdate == "" {
+				fk.OnUpdate = s.DefaultOnUpdate
+			}
+			r = append(r, fk)
 		}
 		return true
 	})
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Table != r[j].Table {
+			return r[i].Table < r[j].Table
+		}
+		return r[i].Name < r[j].Name
+	})
+	return r, errs.ErrorOrNil()
 }
 
 func (s *DDL) MatchTableName(structType reflect.Type, tableName string) bool {
@@ -141,21 +281,51 @@ func (s *DDL) GetSchema(obj interface{}) *schema.Schema {
 
 type FKInfo struct {
 	StructName string
+	RefField   string // referenced field, primary key if empty
+	Name       string // constraint name, fields with the same name form a composite foreign key
 	OnDelete   FKAction
 	OnUpdate   FKAction
 }
 
 // tag: eg. FK:User,CASCADE,CASCADE  Table,on delete %s,on update %s
-func (s *DDL) ParseFKInfo(tag string) FKInfo {
+//	FK:User.Email,onDelete=SET NULL  ref unique field, options by key: name, onDelete, onUpdate
+//	FK:Order.TenantID,name=fk_items_order and FK:Order.Number,name=fk_items_order  composite foreign key on two fields
+func (s *DDL) ParseFKInfo(tag string) (FKInfo, error) {
 	parts := strings.Split(tag, ",")
 	r := FKInfo{}
-	r.StructName = strings.TrimSpace(parts[0])
-	if len(parts) > 1 {
-		r.OnDelete = FKAction(strings.TrimSpace(parts[1]))
+	target := strings.SplitN(strings.TrimSpace(parts[0]), ".", 2)
+	r.StructName = target[0]
+	if len(target) > 1 {
+		r.RefField = target[1]
 	}
-	if len(parts) > 2 {
-		r.OnUpdate = FKAction(strings.TrimSpace(parts[2]))
+	if r.StructName == "" {
+		return r, fmt.Errorf("FK tag %q has no struct", tag)
 	}
-	return r
-
+	for i, part := range parts[1:] {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) == 1 {
+			// positional actions
+			switch i {
+			case 0:
+				r.OnDelete = FKAction(strings.TrimSpace(part))
+			case 1:
+				r.OnUpdate = FKAction(strings.TrimSpace(part))
+			default:
+				return r, fmt.Errorf("FK tag %q has too many actions", tag)
+			}
+			continue
+		}
+		value := strings.TrimSpace(kv[1])
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "name":
+			r.Name = value
+		case "ondelete":
+			r.OnDelete = FKAction(value)
+		case "onupdate":
+			r.OnUpdate = FKAction(value)
+		default:
+			return r, fmt.Errorf("FK tag %q has unknown option %s", tag, kv[0])
+		}
+	}
+	return r, nil
 }
@@ -0,0 +1,202 @@
+package tablecache
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm/schema"
+)
+
+// CheckConstraint CHECK constraint declared by gorm check tag or read from db. eg.
+//	Age int `gorm:"check:chk_users_age,age >= 0"`
+type CheckConstraint struct {
+	Struct string // struct declaring the tag, empty if read from db
+	Name   string
+	Table  string
+	Expr   string
+}
+
+const mysqlCheckQuery = `SELECT tc.CONSTRAINT_NAME AS name, cc.CHECK_CLAUSE AS expr
+FROM information_schema.TABLE_CONSTRAINTS tc
+JOIN information_schema.CHECK_CONSTRAINTS cc ON cc.CONSTRAINT_SCHEMA = tc.CONSTRAINT_SCHEMA AND cc.CONSTRAINT_NAME = tc.CONSTRAINT_NAME
+WHERE tc.TABLE_SCHEMA = DATABASE() AND tc.TABLE_NAME = ? AND tc.CONSTRAINT_TYPE = 'CHECK'
+ORDER BY tc.CONSTRAINT_NAME`
+
+const postgresCheckQuery = `SELECT con.conname AS name, pg_get_constraintdef(con.oid) AS expr
+FROM pg_constraint con
+JOIN pg_class rel ON rel.oid = con.conrelid
+JOIN pg_namespace ns ON ns.oid = rel.relnamespace
+WHERE con.contype = 'c' AND ns.nspname = current_schema() AND rel.relname = ?
+ORDER BY con.conname`
+
+var checkItemRe = regexp.MustCompile("(?is)^(?:CONSTRAINT\\s+[\"`\\[]?([^\"`\\]\\s]+)[\"`\\]]?\\s+)?CHECK\\s*\\((.*)\\)\\s*$")
+
+// Checks existing CHECK constraints of table, read from db catalog. sqlite checks are table constraints of CREATE TABLE sql
+func (s *DDL) Checks(table string) ([]CheckConstraint, error) {
+	if err := s.checkDialect(); err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Name string `gorm:"column:name"`
+		Expr string `gorm:"column:expr"`
+	}
+	var err error
+	switch s.Dialect() {
+	case DialectMySQL:
+		err = s.db.Raw(mysqlCheckQuery, table).Scan(&rows).Error
+	case DialectPostgres:
+		err = s.db.Raw(postgresCheckQuery, table).Scan(&rows).Error
+	case DialectSQLite:
+		var createSQL string
+		if createSQL, err = s.sqliteTableSQL(table); err != nil {
+			return nil, err
+		}
+		_, items, _, err := splitCreateTable(createSQL)
+		if err != nil {
+			return nil, err
+		}
+		var r []CheckConstraint
+		for _, item := range items {
+			if m := checkItemRe.FindStringSubmatch(item); m != nil {
+				r = append(r, CheckConstraint{Name: m[1], Table: table, Expr: m[2]})
+			}
+		}
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := make([]CheckConstraint, len(rows))
+	for i, row := range rows {
+		// postgres gives CHECK (expr)
+		if m := checkItemRe.FindStringSubmatch(row.Expr); m != nil {
+			row.Expr = m[2]
+		}
+		r[i] = CheckConstraint{Name: row.Name, Table: table, Expr: row.Expr}
+	}
+	return r, nil
+}
+
+// DeclaredChecks CHECK constraints declared by check tags of all tables, sorted by table and name
+func (s *DDL) DeclaredChecks() []CheckConstraint {
+	var r []CheckConstraint
+	s.Range(func(structType reflect.Type, tableSchema *schema.Schema) bool {
+		for _, chk := range tableSchema.ParseCheckConstraints() {
+			r = append(r, CheckConstraint{Struct: tableSchema.Name, Name: chk.Name, Table: tableSchema.Table, Expr: chk.Constraint})
+		}
+		return true
+	})
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Table != r[j].Table {
+			return r[i].Table < r[j].Table
+		}
+		return r[i].Name < r[j].Name
+	})
+	return r
+}
+
+func (s *DDL) checkClause(chk CheckConstraint) string {
+	return fmt.Sprintf("CONSTRAINT %s CHECK (%s)", s.quote(chk.Name), chk.Expr)
+}
+
+// alterChecks statements to drop and add CHECK constraints of one table, and CREATE TABLE sql after them for sqlite
+func (s *DDL) alterChecks(table string, drops []CheckConstraint, adds []CheckConstraint) ([]string, string, error) {
+	if err := s.checkDialect(); err != nil {
+		return nil, "", err
+	}
+	if len(drops) == 0 && len(adds) == 0 {
+		return nil, "", nil
+	}
+	var r []string
+	switch s.Dialect() {
+	case DialectMySQL:
+		for _, chk := range drops {
+			r = append(r, fmt.Sprintf("ALTER TABLE %s DROP CHECK %s", s.quote(table), s.quote(chk.Name)))
+		}
+	case DialectPostgres:
+		for _, chk := range drops {
+			r = append(r, fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", s.quote(table), s.quote(chk.Name)))
+		}
+	case DialectSQLite:
+		var items []string
+		for _, chk := range adds {
+			items = append(items, s.checkClause(chk))
+		}
+		return s.sqliteRebuild(table, func(item string) bool {
+			m := checkItemRe.FindStringSubmatch(item)
+			for _, chk := range drops {
+				if m != nil && strings.EqualFold(m[1], chk.Name) {
+					return true
+				}
+			}
+			return false
+		}, len(drops), items)
+	}
+	for _, chk := range adds {
+		r = append(r, fmt.Sprintf("ALTER TABLE %s ADD %s", s.quote(table), s.checkClause(chk)))
+	}
+	return r, "", nil
+}
+
+// addChecks add CHECK constraints to table, or record them in script mode
+func (s *DDL) addChecks(table string, adds []CheckConstraint) error {
+	stmts, createSQL, err := s.alterChecks(table, nil, adds)
+	if err != nil {
+		return err
+	}
+	if s.script == nil {
+		return s.execSQL(stmts)
+	}
+	s.pendTable(table, createSQL)
+	rollback, _, err := s.alterChecks(table, adds, nil)
+	if err != nil {
+		return err
+	}
+	comments := make([]string, len(adds))
+	for i, chk := range adds {
+		comments[i] = fmt.Sprintf("add %s: %s.CHECK (%s)", chk.Name, chk.Struct, chk.Expr)
+	}
+	s.recordSQL(comments, stmts, rollback)
+	return nil
+}
+
+// ensureChecks add declared CHECK constraints missing in db by name. existing ones are kept, dbs rewrite expressions.
+// gorm AutoMigrate adds them too, this covers what it does not: sqlite tables are rebuilt since sqlite can not ALTER a
+// constraint in, tables not migrated by gorm are checked, and Script records the statements instead of running them
+func (s *DDL) ensureChecks(declared []CheckConstraint) error {
+	var errs MultiError
+	byTable := make(map[string][]CheckConstraint)
+	var tables []string
+	for _, chk := range declared {
+		if _, ok := byTable[chk.Table]; !ok {
+			tables = append(tables, chk.Table)
+		}
+		byTable[chk.Table] = append(byTable[chk.Table], chk)
+	}
+	for _, table := range tables {
+		existing, err := s.Checks(table)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		var adds []CheckConstraint
+		for _, chk := range byTable[table] {
+			found := false
+			for _, e := range existing {
+				if strings.EqualFold(e.Name, chk.Name) {
+					found = true
+				}
+			}
+			if !found {
+				adds = append(adds, chk)
+			}
+		}
+		if err := s.addChecks(table, adds); err != nil {
+			errs = append(errs, fmt.Errorf("add checks on %s: %w", table, err))
+		}
+	}
+	return errs.ErrorOrNil()
+}
@@ -0,0 +1,113 @@
+package tablecache
+
+import "strings"
+
+// DBColumn column of table in db catalog
+type DBColumn struct {
+	Name          string
+	Type          string // db type with length, eg. varchar(100), bigint unsigned
+	Nullable      bool
+	Default       *string
+	AutoIncrement bool
+}
+
+const mysqlTablesQuery = `SELECT TABLE_NAME FROM information_schema.TABLES
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_TYPE = 'BASE TABLE' ORDER BY TABLE_NAME`
+
+const postgresTablesQuery = `SELECT tablename FROM pg_tables WHERE schemaname = current_schema() ORDER BY tablename`
+
+const sqliteTablesQuery = `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`
+
+const mysqlColumnsQuery = `SELECT COLUMN_NAME AS name, COLUMN_TYPE AS type, IS_NULLABLE = 'YES' AS nullable,
+	COLUMN_DEFAULT AS default_value, EXTRA LIKE '%auto_increment%' AS auto_increment
+FROM information_schema.COLUMNS
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+ORDER BY ORDINAL_POSITION`
+
+const postgresColumnsQuery = `SELECT att.attname AS name, format_type(att.atttypid, att.atttypmod) AS type, NOT att.attnotnull AS nullable,
+	pg_get_expr(def.adbin, def.adrelid) AS default_value,
+	att.attidentity <> '' OR COALESCE(pg_get_expr(def.adbin, def.adrelid), '') LIKE 'nextval(%' AS auto_increment
+FROM pg_attribute att
+JOIN pg_class rel ON rel.oid = att.attrelid
+JOIN pg_namespace ns ON ns.oid = rel.relnamespace
+LEFT JOIN pg_attrdef def ON def.adrelid = att.attrelid AND def.adnum = att.attnum
+WHERE ns.nspname = current_schema() AND rel.relname = ? AND att.attnum > 0 AND NOT att.attisdropped
+ORDER BY att.attnum`
+
+// Tables names of tables in current database or schema
+func (s *DDL) Tables() ([]string, error) {
+	if err := s.checkDialect(); err != nil {
+		return nil, err
+	}
+	query := map[string]string{
+		DialectMySQL:    mysqlTablesQuery,
+		DialectPostgres: postgresTablesQuery,
+		DialectSQLite:   sqliteTablesQuery,
+	}[s.Dialect()]
+	var r []string
+	err := s.db.Raw(query).Scan(&r).Error
+	return r, err
+}
+
+// Columns columns of table in order, read from db catalog
+func (s *DDL) Columns(table string) ([]DBColumn, error) {
+	if err := s.checkDialect(); err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Name          string  `gorm:"column:name"`
+		Type          string  `gorm:"column:type"`
+		Nullable      bool    `gorm:"column:nullable"`
+		Default       *string `gorm:"column:default_value"`
+		AutoIncrement bool    `gorm:"column:auto_increment"`
+	}
+	var err error
+	switch s.Dialect() {
+	case DialectMySQL:
+		err = s.db.Raw(mysqlColumnsQuery, table).Scan(&rows).Error
+	case DialectPostgres:
+		err = s.db.Raw(postgresColumnsQuery, table).Scan(&rows).Error
+	case DialectSQLite:
+		return s.sqliteColumns(table)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r := make([]DBColumn, len(rows))
+	for i, row := range rows {
+		r[i] = DBColumn{Name: row.Name, Type: row.Type, Nullable: row.Nullable, Default: row.Default, AutoIncrement: row.AutoIncrement}
+	}
+	return r, nil
+}
+
+// sqliteColumns the only INTEGER PRIMARY KEY column is an alias of rowid, which auto increments
+func (s *DDL) sqliteColumns(table string) ([]DBColumn, error) {
+	var info []struct {
+		Name    string  `gorm:"column:name"`
+		Type    string  `gorm:"column:type"`
+		NotNull bool    `gorm:"column:notnull"`
+		Default *string `gorm:"column:dflt_value"`
+		PK      int     `gorm:"column:pk"`
+	}
+	err := s.db.Raw("PRAGMA table_info(" + s.quote(table) + ")").Scan(&info).Error
+	if err != nil {
+		return nil, err
+	}
+	pks := 0
+	for _, c := range info {
+		if c.PK > 0 {
+			pks++
+		}
+	}
+	r := make([]DBColumn, len(info))
+	for i, c := range info {
+		r[i] = DBColumn{
+			Name:          c.Name,
+			Type:          c.Type,
+			Nullable:      !c.NotNull && c.PK == 0,
+			Default:       c.Default,
+			AutoIncrement: c.PK > 0 && pks == 1 && strings.EqualFold(c.Type, "integer"),
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,443 @@
+package tablecache
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var ErrDialectUnsupported = errors.New("dialect not supported")
+
+const (
+	DialectMySQL    = "mysql"
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+)
+
+// ForeignKey constraint declared by FK tag or read from db
+type ForeignKey struct {
+	Struct     string   // struct declaring the tag, empty if read from db
+	Fields     []string // fields declaring the tag
+	Name       string
+	Table      string
+	Columns    []string
+	RefStruct  string   // referenced struct, empty if read from db
+	RefFields  []string // referenced fields, empty if read from db
+	RefTable   string
+	RefColumns []string
+	OnDelete   FKAction
+	OnUpdate   FKAction
+}
+
+// normalizeAction empty -> NO ACTION. mysql treats RESTRICT as NO ACTION
+func normalizeAction(dialect string, action FKAction) FKAction {
+	a := FKAction(strings.ToUpper(strings.TrimSpace(string(action))))
+	if a == FKEmpty || (dialect == DialectMySQL && a == FKRestrict) {
+		return FKNoAction
+	}
+	return a
+}
+
+// SameTarget same table, columns and referenced columns. empty ref columns (sqlite, implicit primary key) match any
+func (s ForeignKey) SameTarget(o ForeignKey) bool {
+	if !strings.EqualFold(s.Table, o.Table) || !strings.EqualFold(s.RefTable, o.RefTable) || !equalFoldStrs(s.Columns, o.Columns) {
+		return false
+	}
+	return len(s.RefColumns) == 0 || len(o.RefColumns) == 0 || equalFoldStrs(s.RefColumns, o.RefColumns)
+}
+
+// SameName names are equal, false if any is unknown
+func (s ForeignKey) SameName(o ForeignKey) bool {
+	return s.Name != "" && strings.EqualFold(s.Name, o.Name)
+}
+
+func equalFoldStrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Dialect name of db dialect, eg. mysql
+func (s *DDL) Dialect() string {
+	return s.db.Dialector.Name()
+}
+
+func (s *DDL) checkDialect() error {
+	switch s.Dialect() {
+	case DialectMySQL, DialectPostgres, DialectSQLite:
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrDialectUnsupported, s.Dialect())
+}
+
+func (s *DDL) quote(name string) string {
+	var b strings.Builder
+	s.db.Dialector.QuoteTo(&b, name)
+	return b.String()
+}
+
+func (s *DDL) quoteAll(names []string) string {
+	r := make([]string, len(names))
+	for i, v := range names {
+		r[i] = s.quote(v)
+	}
+	return strings.Join(r, ", ")
+}
+
+// fkRow one column of a foreign key in catalog
+type fkRow struct {
+	Name      string `gorm:"column:name"`
+	TableName string `gorm:"column:table_name"`
+	Column    string `gorm:"column:column_name"`
+	RefTable  string `gorm:"column:ref_table"`
+	RefColumn string `gorm:"column:ref_column"`
+	OnDelete  string `gorm:"column:on_delete"`
+	OnUpdate  string `gorm:"column:on_update"`
+}
+
+const mysqlFKQuery = `SELECT k.CONSTRAINT_NAME AS name, k.TABLE_NAME AS table_name, k.COLUMN_NAME AS column_name,
+	k.REFERENCED_TABLE_NAME AS ref_table, k.REFERENCED_COLUMN_NAME AS ref_column, r.DELETE_RULE AS on_delete, r.UPDATE_RULE AS on_update
+FROM information_schema.KEY_COLUMN_USAGE k
+JOIN information_schema.REFERENTIAL_CONSTRAINTS r
+	ON r.CONSTRAINT_SCHEMA = k.CONSTRAINT_SCHEMA AND r.CONSTRAINT_NAME = k.CONSTRAINT_NAME AND r.TABLE_NAME = k.TABLE_NAME
+WHERE k.TABLE_SCHEMA = DATABASE() AND k.TABLE_NAME = ? AND k.REFERENCED_TABLE_NAME IS NOT NULL
+ORDER BY k.CONSTRAINT_NAME, k.ORDINAL_POSITION`
+
+const postgresFKQuery = `SELECT con.conname AS name, rel.relname AS table_name, att.attname AS column_name,
+	frel.relname AS ref_table, fatt.attname AS ref_column, con.confdeltype AS on_delete, con.confupdtype AS on_update
+FROM pg_constraint con
+JOIN pg_class rel ON rel.oid = con.conrelid
+JOIN pg_namespace ns ON ns.oid = rel.relnamespace
+JOIN pg_class frel ON frel.oid = con.confrelid
+CROSS JOIN LATERAL unnest(con.conkey, con.confkey) WITH ORDINALITY AS k(attnum, fattnum, ord)
+JOIN pg_attribute att ON att.attrelid = con.conrelid AND att.attnum = k.attnum
+JOIN pg_attribute fatt ON fatt.attrelid = con.confrelid AND fatt.attnum = k.fattnum
+WHERE con.contype = 'f' AND ns.nspname = current_schema() AND rel.relname = ?
+ORDER BY con.conname, k.ord`
+
+// postgres confdeltype/confupdtype codes
+var postgresActions = map[string]FKAction{
+	"a": FKNoAction,
+	"r": FKRestrict,
+	"c": FKCascade,
+	"n": FKSetNull,
+	"d": "SET DEFAULT",
+}
+
+// ForeignKeys existing foreign keys of table, read from db catalog
+func (s *DDL) ForeignKeys(table string) ([]ForeignKey, error) {
+	if err := s.checkDialect(); err != nil {
+		return nil, err
+	}
+	var rows []fkRow
+	var err error
+	switch s.Dialect() {
+	case DialectMySQL:
+		err = s.db.Raw(mysqlFKQuery, table).Scan(&rows).Error
+	case DialectPostgres:
+		err = s.db.Raw(postgresFKQuery, table).Scan(&rows).Error
+		for i := range rows {
+			rows[i].OnDelete = string(postgresActions[rows[i].OnDelete])
+			rows[i].OnUpdate = string(postgresActions[rows[i].OnUpdate])
+		}
+	case DialectSQLite:
+		return s.sqliteForeignKeys(table)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var r []ForeignKey
+	for _, row := range rows {
+		if len(r) == 0 || r[len(r)-1].Name != row.Name {
+			r = append(r, ForeignKey{
+				Name:     row.Name,
+				Table:    row.TableName,
+				RefTable: row.RefTable,
+				OnDelete: FKAction(row.OnDelete),
+				OnUpdate: FKAction(row.OnUpdate),
+			})
+		}
+		fk := &r[len(r)-1]
+		fk.Columns = append(fk.Columns, row.Column)
+		fk.RefColumns = append(fk.RefColumns, row.RefColumn)
+	}
+	return r, nil
+}
+
+// sqliteForeignKeys PRAGMA foreign_key_list has no constraint names, names are parsed from CREATE TABLE sql
+func (s *DDL) sqliteForeignKeys(table string) ([]ForeignKey, error) {
+	var rows []struct {
+		ID       int     `gorm:"column:id"`
+		Table    string  `gorm:"column:table"`
+		From     string  `gorm:"column:from"`
+		To       *string `gorm:"column:to"`
+		OnUpdate string  `gorm:"column:on_update"`
+		OnDelete string  `gorm:"column:on_delete"`
+	}
+	err := s.db.Raw("PRAGMA foreign_key_list(" + s.quote(table) + ")").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	var r []ForeignKey
+	ids := make(map[int]int)
+	for _, row := range rows {
+		i, ok := ids[row.ID]
+		if !ok {
+			i = len(r)
+			ids[row.ID] = i
+			r = append(r, ForeignKey{
+				Table:    table,
+				RefTable: row.Table,
+				OnDelete: FKAction(row.OnDelete),
+				OnUpdate: FKAction(row.OnUpdate),
+			})
+		}
+		r[i].Columns = append(r[i].Columns, row.From)
+		if row.To != nil && *row.To != "" {
+			r[i].RefColumns = append(r[i].RefColumns, *row.To)
+		}
+	}
+	createSQL, err := s.sqliteTableSQL(table)
+	if err != nil {
+		return nil, err
+	}
+	_, items, _, err := splitCreateTable(createSQL)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		name, columns, ok := parseFKItem(item)
+		if !ok || name == "" {
+			continue
+		}
+		for i := range r {
+			if equalFoldStrs(r[i].Columns, columns) {
+				r[i].Name = name
+			}
+		}
+	}
+	return r, nil
+}
+
+// sqliteTableSQL CREATE TABLE sql of table, as changed by statements of script in script mode
+func (s *DDL) sqliteTableSQL(table string) (string, error) {
+	if s.script != nil {
+		if createSQL, ok := s.script.tables[table]; ok {
+			return createSQL, nil
+		}
+	}
+	var createSQL string
+	err := s.db.Raw("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", table).Row().Scan(&createSQL)
+	return createSQL, err
+}
+
+// splitCreateTable CREATE TABLE sql -> head "CREATE TABLE x (", items of top level, tail ")..."
+func splitCreateTable(createSQL string) (string, []string, string, error) {
+	start := strings.Index(createSQL, "(")
+	end := strings.LastIndex(createSQL, ")")
+	if start < 0 || end < start {
+		return "", nil, "", fmt.Errorf("can not parse %s", createSQL)
+	}
+	body := createSQL[start+1 : end]
+	var items []string
+	depth := 0
+	var quote rune
+	last := 0
+	for i, c := range body {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, strings.TrimSpace(body[last:i]))
+			last = i + 1
+		}
+	}
+	items = append(items, strings.TrimSpace(body[last:]))
+	return createSQL[:start+1], items, createSQL[end:], nil
+}
+
+var fkItemRe = regexp.MustCompile("(?is)^(?:CONSTRAINT\\s+[\"`\\[]?([^\"`\\]\\s]+)[\"`\\]]?\\s+)?FOREIGN\\s+KEY\\s*\\(([^)]*)\\)")
+
+// parseFKItem table constraint item of CREATE TABLE -> name, columns
+func parseFKItem(item string) (string, []string, bool) {
+	m := fkItemRe.FindStringSubmatch(item)
+	if m == nil {
+		return "", nil, false
+	}
+	var columns []string
+	for _, c := range strings.Split(m[2], ",") {
+		columns = append(columns, strings.Trim(strings.TrimSpace(c), "\"`[]"))
+	}
+	return m[1], columns, true
+}
+
+// fkClause CONSTRAINT n FOREIGN KEY (c) REFERENCES t (c) ON DELETE x ON UPDATE y
+func (s *DDL) fkClause(fk ForeignKey) string {
+	r := fmt.Sprintf("CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)", s.quote(fk.Name), s.quoteAll(fk.Columns), s.quote(fk.RefTable), s.quoteAll(fk.RefColumns))
+	if fk.OnDelete != FKEmpty {
+		r += " ON DELETE " + string(fk.OnDelete)
+	}
+	if fk.OnUpdate != FKEmpty {
+		r += " ON UPDATE " + string(fk.OnUpdate)
+	}
+	return r
+}
+
+// alterFKsSQL statements to drop and add foreign keys of one table.
+// sqlite can not alter constraints, the table is rebuilt with foreign_keys off
+func (s *DDL) alterFKsSQL(table string, drops []ForeignKey, adds []ForeignKey) ([]string, error) {
+	r, _, err := s.alterFKs(table, drops, adds)
+	return r, err
+}
+
+// alterFKs statements, and CREATE TABLE sql after them for sqlite
+func (s *DDL) alterFKs(table string, drops []ForeignKey, adds []ForeignKey) ([]string, string, error) {
+	if err := s.checkDialect(); err != nil {
+		return nil, "", err
+	}
+	if len(drops) == 0 && len(adds) == 0 {
+		return nil, "", nil
+	}
+	var r []string
+	switch s.Dialect() {
+	case DialectMySQL:
+		for _, fk := range drops {
+			r = append(r, fmt.Sprintf("ALTER TABLE %s DROP FOREIGN KEY %s", s.quote(table), s.quote(fk.Name)))
+		}
+	case DialectPostgres:
+		for _, fk := range drops {
+			r = append(r, fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", s.quote(table), s.quote(fk.Name)))
+		}
+	case DialectSQLite:
+		return s.sqliteRebuildSQL(table, drops, adds)
+	}
+	for _, fk := range adds {
+		r = append(r, fmt.Sprintf("ALTER TABLE %s ADD %s", s.quote(table), s.fkClause(fk)))
+	}
+	return r, "", nil
+}
+
+const (
+	sqliteFKOff = "PRAGMA foreign_keys = OFF"
+	sqliteFKOn  = "PRAGMA foreign_keys = ON"
+)
+
+// sqliteRebuildSQL rebuild table with foreign keys dropped and added
+func (s *DDL) sqliteRebuildSQL(table string, drops []ForeignKey, adds []ForeignKey) ([]string, string, error) {
+	var items []string
+	for _, fk := range adds {
+		items = append(items, s.fkClause(fk))
+	}
+	return s.sqliteRebuild(table, func(item string) bool {
+		_, columns, ok := parseFKItem(item)
+		for _, fk := range drops {
+			if ok && equalFoldStrs(fk.Columns, columns) {
+				return true
+			}
+		}
+		return false
+	}, len(drops), items)
+}
+
+// sqliteRebuild 12 steps of https://www.sqlite.org/lang_altertable.html, indexes are re-created.
+// dropCount table constraints are dropped by drop, items are added
+func (s *DDL) sqliteRebuild(table string, drop func(item string) bool, dropCount int, adds []string) ([]string, string, error) {
+	createSQL, err := s.sqliteTableSQL(table)
+	if err != nil {
+		return nil, "", err
+	}
+	_, items, tail, err := splitCreateTable(createSQL)
+	if err != nil {
+		return nil, "", err
+	}
+	var kept []string
+	for _, item := range items {
+		if !drop(item) {
+			kept = append(kept, item)
+		}
+	}
+	if len(kept) != len(items)-dropCount {
+		return nil, "", fmt.Errorf("sqlite: constraints of %s to drop are not all named table constraints", table)
+	}
+	kept = append(kept, adds...)
+	newTable := table + "__new"
+	var indexes []string
+	err = s.db.Raw("SELECT sql FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL", table).Scan(&indexes).Error
+	if err != nil {
+		return nil, "", err
+	}
+	body := " (" + strings.Join(kept, ", ") + tail
+	r := []string{
+		sqliteFKOff,
+		"BEGIN",
+		"CREATE TABLE " + s.quote(newTable) + body,
+		fmt.Sprintf("INSERT INTO %s SELECT * FROM %s", s.quote(newTable), s.quote(table)),
+		"DROP TABLE " + s.quote(table),
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", s.quote(newTable), s.quote(table)),
+	}
+	r = append(r, indexes...)
+	r = append(r, "COMMIT", sqliteFKOn)
+	return r, "CREATE TABLE " + s.quote(table) + body, nil
+}
+
+// execSQL run statements on one connection, pragmas are per connection. foreign_keys of sqlite is restored as it was
+func (s *DDL) execSQL(stmts []string) error {
+	if len(stmts) == 0 {
+		return nil
+	}
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	ctx := s.db.Statement.Context
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	fkOn := true
+	if s.Dialect() == DialectSQLite {
+		if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&fkOn); err != nil {
+			return err
+		}
+	}
+	inTx := false
+	for _, stmt := range stmts {
+		if stmt == sqliteFKOn && !fkOn {
+			continue
+		}
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			if inTx {
+				conn.ExecContext(ctx, "ROLLBACK")
+			}
+			if s.Dialect() == DialectSQLite && fkOn {
+				conn.ExecContext(ctx, sqliteFKOn)
+			}
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+		switch stmt {
+		case "BEGIN":
+			inTx = true
+		case "COMMIT":
+			inTx = false
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package tablecache
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm/schema"
+)
+
+// erTable table of diagram
+type erTable struct {
+	schema  *schema.Schema
+	columns []*schema.Field
+	fkCols  map[string]bool
+	cache   *TableCache
+	indexes map[string][]string // column: cached indexes on it
+}
+
+// erModel tables added by AddTables, sorted, and their foreign keys. caches annotate tables
+func (s *DDL) erModel(caches []*TableCache) ([]*erTable, []ForeignKey, error) {
+	fks, err := s.DeclaredFKs()
+	if err != nil {
+		return nil, nil, err
+	}
+	byTable := make(map[string]*erTable)
+	s.Range(func(structType reflect.Type, tableSchema *schema.Schema) bool {
+		t := &erTable{schema: tableSchema, fkCols: make(map[string]bool), indexes: make(map[string][]string)}
+		for _, f := range tableSchema.Fields {
+			if f.DBName != "" {
+				t.columns = append(t.columns, f)
+			}
+		}
+		byTable[tableSchema.Table] = t
+		return true
+	})
+	for _, fk := range fks {
+		if t, ok := byTable[fk.Table]; ok {
+			for _, c := range fk.Columns {
+				t.fkCols[c] = true
+			}
+		}
+	}
+	for _, c := range caches {
+		sch := s.GetSchemaByStructName(c.structName)
+		if sch == nil {
+			continue
+		}
+		t := byTable[sch.Table]
+		t.cache = c
+		for _, idx := range c.Indexes {
+			for _, f := range idx.Fields {
+				if field := sch.LookUpField(f); field != nil {
+					t.indexes[field.DBName] = append(t.indexes[field.DBName], idx.String())
+				}
+			}
+		}
+	}
+	r := make([]*erTable, 0, len(byTable))
+	for _, t := range byTable {
+		r = append(r, t)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].schema.Table < r[j].schema.Table
+	})
+	return r, fks, nil
+}
+
+// key and auto increment clauses which some dialects put into data type
+var typeClauses = regexp.MustCompile(`(?i)\s+(PRIMARY\s+KEY|AUTO_?INCREMENT).*$`)
+
+// columnType db type of field in dialect of db, eg. bigint unsigned
+func (s *DDL) columnType(f *schema.Field) string {
+	if t := typeClauses.ReplaceAllString(s.db.Dialector.DataTypeOf(f), ""); t != "" {
+		return t
+	}
+	return string(f.DataType)
+}
+
+func fkActionsLabel(fk ForeignKey, sep string) string {
+	var r []string
+	if fk.OnDelete != FKEmpty {
+		r = append(r, "ON DELETE "+string(fk.OnDelete))
+	}
+	if fk.OnUpdate != FKEmpty {
+		r = append(r, "ON UPDATE "+string(fk.OnUpdate))
+	}
+	return strings.Join(r, sep)
+}
+
+// fkNullable any column of fk is nullable
+func (t *erTable) fkNullable(fk ForeignKey) bool {
+	for _, c := range fk.Columns {
+		if f := t.schema.LookUpField(c); f != nil && !f.NotNull && !f.PrimaryKey && f.FieldType.Kind() == reflect.Ptr {
+			return true
+		}
+	}
+	return false
+}
+
+var mermaidUnsafe = regexp.MustCompile(`[^A-Za-z0-9_()\[\]-]+`)
+
+// ExportMermaid write tables and foreign keys as mermaid erDiagram. tables and indexes of caches are annotated
+func (s *DDL) ExportMermaid(w io.Writer, caches ...*TableCache) error {
+	tables, fks, err := s.erModel(caches)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	b.WriteString("erDiagram\n")
+	byTable := make(map[string]*erTable, len(tables))
+	for _, t := range tables {
+		byTable[t.schema.Table] = t
+		if t.cache != nil {
+			fmt.Fprintf(&b, "    %%%% %s is cached by TableCache %s\n", t.schema.Table, t.cache.structName)
+		}
+		fmt.Fprintf(&b, "    %s {\n", t.schema.Table)
+		for _, f := range t.columns {
+			line := mermaidUnsafe.ReplaceAllString(s.columnType(f), "_") + " " + f.DBName
+			var keys []string
+			if f.PrimaryKey {
+				keys = append(keys, "PK")
+			}
+			if t.fkCols[f.DBName] {
+				keys = append(keys, "FK")
+			}
+			if len(keys) > 0 {
+				line += " " + strings.Join(keys, ",")
+			}
+			if idx := t.indexes[f.DBName]; len(idx) > 0 {
+				line += fmt.Sprintf(" \"cached %s\"", strings.Join(idx, " "))
+			}
+			fmt.Fprintf(&b, "        %s\n", line)
+		}
+		b.WriteString("    }\n")
+	}
+	for _, fk := range fks {
+		card := "}o--||"
+		if t, ok := byTable[fk.Table]; ok && t.fkNullable(fk) {
+			card = "}o--o|"
+		}
+		label := fk.Name
+		if actions := fkActionsLabel(fk, " "); actions != "" {
+			label += " " + actions
+		}
+		fmt.Fprintf(&b, "    %s %s %s : \"%s\"\n", fk.Table, card, fk.RefTable, label)
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// ExportDOT write tables and foreign keys as graphviz digraph, edges go from fk columns to referenced columns, one per column pair.
+// tables and indexes of caches are annotated
+func (s *DDL) ExportDOT(w io.Writer, caches ...*TableCache) error {
+	tables, fks, err := s.erModel(caches)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	b.WriteString("digraph ER {\n    rankdir=LR;\n    node [shape=plaintext];\n")
+	for _, t := range tables {
+		header := "<b>" + html.EscapeString(t.schema.Table) + "</b>"
+		color := "lightgrey"
+		if t.cache != nil {
+			header += "<br/>cached by " + html.EscapeString(t.cache.structName)
+			color = "lightblue"
+		}
+		fmt.Fprintf(&b, "    %q [label=<<table border=\"0\" cellborder=\"1\" cellspacing=\"0\">\n", t.schema.Table)
+		fmt.Fprintf(&b, "        <tr><td bgcolor=\"%s\">%s</td></tr>\n", color, header)
+		for _, f := range t.columns {
+			cell := html.EscapeString(f.DBName + ": " + s.columnType(f))
+			if f.PrimaryKey {
+				cell += " PK"
+			}
+			if t.fkCols[f.DBName] {
+				cell += " FK"
+			}
+			if idx := t.indexes[f.DBName]; len(idx) > 0 {
+				cell += "<br/><i>cached " + html.EscapeString(strings.Join(idx, " ")) + "</i>"
+			}
+			fmt.Fprintf(&b, "        <tr><td port=%q align=\"left\">%s</td></tr>\n", f.DBName, cell)
+		}
+		b.WriteString("    </table>>];\n")
+	}
+	for _, fk := range fks {
+		// one edge per column pair of composite keys
+		for i, col := range fk.Columns {
+			label := fk.Name
+			if len(fk.Columns) > 1 {
+				label += fmt.Sprintf(" (%d/%d)", i+1, len(fk.Columns))
+			}
+			fmt.Fprintf(&b, "    %q:%q -> %q:%q [label=%q];\n", fk.Table, col, fk.RefTable, fk.RefColumns[i], label+"\n"+fkActionsLabel(fk, "\n"))
+		}
+	}
+	b.WriteString("}\n")
+	_, err = io.WriteString(w, b.String())
+	return err
+}
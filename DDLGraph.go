@@ -0,0 +1,195 @@
+package tablecache
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"gorm.io/gorm/schema"
+)
+
+var ErrFKCycle = errors.New("foreign key cycle")
+
+// TableGraph tables added to DDL and foreign keys between them. self references are kept in edges but ignored for ordering
+type TableGraph struct {
+	Tables []string            // sorted
+	Edges  map[string][]string // table: tables it references, sorted
+	models map[string]reflect.Type
+}
+
+//Graph dependency graph of tables added by AddTables, from FK tags
+func (s *DDL) Graph() (*TableGraph, error) {
+	fks, err := s.DeclaredFKs()
+	if err != nil {
+		return nil, err
+	}
+	r := &TableGraph{
+		Edges:  make(map[string][]string),
+		models: make(map[string]reflect.Type),
+	}
+	s.Range(func(structType reflect.Type, tableSchema *schema.Schema) bool {
+		if _, ok := r.models[tableSchema.Table]; !ok {
+			r.Tables = append(r.Tables, tableSchema.Table)
+		}
+		r.models[tableSchema.Table] = tableSchema.ModelType
+		return true
+	})
+	sort.Strings(r.Tables)
+	for _, fk := range fks {
+		if !containsStr(r.Edges[fk.Table], fk.RefTable) {
+			r.Edges[fk.Table] = append(r.Edges[fk.Table], fk.RefTable)
+		}
+	}
+	for _, refs := range r.Edges {
+		sort.Strings(refs)
+	}
+	return r, nil
+}
+
+func containsStr(a []string, v string) bool {
+	for _, s := range a {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Dependencies tables referenced by table
+func (s *TableGraph) Dependencies(table string) []string {
+	return s.Edges[table]
+}
+
+// Dependents tables referencing table
+func (s *TableGraph) Dependents(table string) []string {
+	var r []string
+	for _, t := range s.Tables {
+		if containsStr(s.Edges[t], table) {
+			r = append(r, t)
+		}
+	}
+	return r
+}
+
+// Model new pointer of model of table, eg. &User{}. nil if table is unknown
+func (s *TableGraph) Model(table string) interface{} {
+	t, ok := s.models[table]
+	if !ok {
+		return nil
+	}
+	return reflect.New(t).Interface()
+}
+
+// Cycles groups of tables referencing each other, self references excluded
+func (s *TableGraph) Cycles() [][]string {
+	// tarjan's strongly connected components
+	index := make(map[string]int)
+	low := make(map[string]int)
+	onStack := make(map[string]bool)
+	var stack []string
+	var r [][]string
+	var visit func(t string)
+	visit = func(t string) {
+		index[t] = len(index)
+		low[t] = index[t]
+		stack = append(stack, t)
+		onStack[t] = true
+		for _, dep := range s.Edges[t] {
+			if _, ok := index[dep]; !ok {
+				visit(dep)
+				if low[dep] < low[t] {
+					low[t] = low[dep]
+				}
+			} else if onStack[dep] && index[dep] < low[t] {
+				low[t] = index[dep]
+			}
+		}
+		if low[t] != index[t] {
+			return
+		}
+		var scc []string
+		for {
+			n := len(stack) - 1
+			v := stack[n]
+			stack = stack[:n]
+			onStack[v] = false
+			scc = append(scc, v)
+			if v == t {
+				break
+			}
+		}
+		if len(scc) > 1 {
+			sort.Strings(scc)
+			r = append(r, scc)
+		}
+	}
+	for _, t := range s.Tables {
+		if _, ok := index[t]; !ok {
+			visit(t)
+		}
+	}
+	return r
+}
+
+// CreationOrder referenced tables first, for AutoMigrate and seeding. ErrFKCycle if tables reference each other
+func (s *TableGraph) CreationOrder() ([]string, error) {
+	if cycles := s.Cycles(); len(cycles) > 0 {
+		return nil, fmt.Errorf("%w: %v", ErrFKCycle, cycles)
+	}
+	// kahn's algorithm, ties in name order
+	pending := make(map[string]int, len(s.Tables))
+	for _, t := range s.Tables {
+		for _, dep := range s.Edges[t] {
+			if dep != t {
+				pending[t]++
+			}
+		}
+	}
+	var ready, r []string
+	for _, t := range s.Tables {
+		if pending[t] == 0 {
+			ready = append(ready, t)
+		}
+	}
+	for len(ready) > 0 {
+		t := ready[0]
+		ready = ready[1:]
+		r = append(r, t)
+		var next []string
+		for _, dependent := range s.Dependents(t) {
+			if dependent == t {
+				continue
+			}
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				next = append(next, dependent)
+			}
+		}
+		ready = append(ready, next...)
+		sort.Strings(ready)
+	}
+	return r, nil
+}
+
+// DropOrder referencing tables first, for dropping and truncating
+func (s *TableGraph) DropOrder() ([]string, error) {
+	r, err := s.CreationOrder()
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return r, err
+}
+
+// CreationModels models in creation order, eg. db.AutoMigrate(models...)
+func (s *TableGraph) CreationModels() ([]interface{}, error) {
+	tables, err := s.CreationOrder()
+	if err != nil {
+		return nil, err
+	}
+	r := make([]interface{}, len(tables))
+	for i, t := range tables {
+		r[i] = s.Model(t)
+	}
+	return r, nil
+}
@@ -0,0 +1,329 @@
+package tablecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm/schema"
+)
+
+var ErrIndexNotBacked = errors.New("cache index has no db index")
+
+// ErrRefNotUnique foreign key references columns which are neither a primary key nor unique
+var ErrRefNotUnique = errors.New("foreign key references non-unique columns")
+
+// DBIndex index of table in db catalog. primary key is an unique index
+type DBIndex struct {
+	Name    string
+	Table   string
+	Columns []string
+	Unique  bool
+	Primary bool
+}
+
+// Covers index can serve WHERE on columns by equality: its leading columns are columns in any order,
+// or it is unique on some of columns
+func (s DBIndex) Covers(columns []string) bool {
+	if len(s.Columns) >= len(columns) {
+		return equalFoldStrs(lowerSorted(s.Columns[:len(columns)]), lowerSorted(columns))
+	}
+	if !s.Unique {
+		return false
+	}
+	for _, c := range s.Columns {
+		if !containsFold(columns, c) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(strs []string, str string) bool {
+	for _, v := range strs {
+		if strings.EqualFold(v, str) {
+			return true
+		}
+	}
+	return false
+}
+
+// IndexReport cache index and the db index backing it
+type IndexReport struct {
+	Struct  string
+	Table   string
+	Index   Index
+	Columns []string
+	DBIndex string // name of covering db index, empty if none
+	Created bool   // created by CheckIndexes
+}
+
+func (s IndexReport) String() string {
+	switch {
+	case s.Created:
+		return fmt.Sprintf("%s.%s: created %s", s.Struct, s.Index, s.DBIndex)
+	case s.DBIndex == "":
+		return fmt.Sprintf("%s.%s: no db index on %s(%s)", s.Struct, s.Index, s.Table, strings.Join(s.Columns, ", "))
+	}
+	return fmt.Sprintf("%s.%s: %s", s.Struct, s.Index, s.DBIndex)
+}
+
+// indexRow one column of an index in catalog
+type indexRow struct {
+	Name      string `gorm:"column:name"`
+	Column    string `gorm:"column:column_name"`
+	NonUnique bool   `gorm:"column:non_unique"`
+	Primary   bool   `gorm:"column:is_primary"`
+}
+
+const mysqlIndexQuery = `SELECT INDEX_NAME AS name, COALESCE(COLUMN_NAME, '') AS column_name, NON_UNIQUE <> 0 AS non_unique,
+	INDEX_NAME = 'PRIMARY' AS is_primary
+FROM information_schema.STATISTICS
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+ORDER BY INDEX_NAME, SEQ_IN_INDEX`
+
+// partial indexes are left out, expression columns have empty names
+const postgresIndexQuery = `SELECT i.relname AS name, COALESCE(att.attname, '') AS column_name, NOT ix.indisunique AS non_unique,
+	ix.indisprimary AS is_primary
+FROM pg_index ix
+JOIN pg_class rel ON rel.oid = ix.indrelid
+JOIN pg_namespace ns ON ns.oid = rel.relnamespace
+JOIN pg_class i ON i.oid = ix.indexrelid
+CROSS JOIN LATERAL unnest(ix.indkey) WITH ORDINALITY AS k(attnum, ord)
+LEFT JOIN pg_attribute att ON att.attrelid = ix.indrelid AND att.attnum = k.attnum
+WHERE ns.nspname = current_schema() AND rel.relname = ? AND ix.indpred IS NULL
+ORDER BY i.relname, k.ord`
+
+// Indexes existing indexes of table, read from db catalog
+func (s *DDL) Indexes(table string) ([]DBIndex, error) {
+	if err := s.checkDialect(); err != nil {
+		return nil, err
+	}
+	var rows []indexRow
+	var err error
+	switch s.Dialect() {
+	case DialectMySQL:
+		err = s.db.Raw(mysqlIndexQuery, table).Scan(&rows).Error
+	case DialectPostgres:
+		err = s.db.Raw(postgresIndexQuery, table).Scan(&rows).Error
+	case DialectSQLite:
+		return s.sqliteIndexes(table)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var r []DBIndex
+	for _, row := range rows {
+		if len(r) == 0 || r[len(r)-1].Name != row.Name {
+			r = append(r, DBIndex{Name: row.Name, Table: table, Unique: !row.NonUnique, Primary: row.Primary})
+		}
+		idx := &r[len(r)-1]
+		idx.Columns = append(idx.Columns, row.Column)
+	}
+	return r, nil
+}
+
+// sqliteIndexes PRAGMA index_list misses rowid primary key, which is added from table info as PRIMARY
+func (s *DDL) sqliteIndexes(table string) ([]DBIndex, error) {
+	var list []struct {
+		Name    string `gorm:"column:name"`
+		Unique  bool   `gorm:"column:unique"`
+		Partial bool   `gorm:"column:partial"`
+		Origin  string `gorm:"column:origin"`
+	}
+	err := s.db.Raw("PRAGMA index_list(" + s.quote(table) + ")").Scan(&list).Error
+	if err != nil {
+		return nil, err
+	}
+	var r []DBIndex
+	hasPK := false
+	for _, v := range list {
+		if v.Partial {
+			continue
+		}
+		hasPK = hasPK || v.Origin == "pk"
+		var columns []struct {
+			Name *string `gorm:"column:name"`
+		}
+		err = s.db.Raw("PRAGMA index_info(" + s.quote(v.Name) + ")").Scan(&columns).Error
+		if err != nil {
+			return nil, err
+		}
+		idx := DBIndex{Name: v.Name, Table: table, Unique: v.Unique, Primary: v.Origin == "pk"}
+		for _, c := range columns {
+			name := ""
+			if c.Name != nil {
+				name = *c.Name
+			}
+			idx.Columns = append(idx.Columns, name)
+		}
+		r = append(r, idx)
+	}
+	if hasPK {
+		return r, nil
+	}
+	var info []struct {
+		Name string `gorm:"column:name"`
+		PK   int    `gorm:"column:pk"`
+	}
+	err = s.db.Raw("PRAGMA table_info(" + s.quote(table) + ")").Scan(&info).Error
+	if err != nil {
+		return nil, err
+	}
+	pk := DBIndex{Name: "PRIMARY", Table: table, Unique: true, Primary: true}
+	for i := 1; ; i++ {
+		found := false
+		for _, c := range info {
+			if c.PK == i {
+				pk.Columns = append(pk.Columns, c.Name)
+				found = true
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	if len(pk.Columns) > 0 {
+		r = append(r, pk)
+	}
+	return r, nil
+}
+
+// MakeIndexName eg. idx_users_project_id_status
+func (s *DDL) MakeIndexName(table string, columns []string) string {
+	return "idx_" + table + "_" + strings.Join(columns, "_")
+}
+
+// createIndex create index, or record it in script mode
+func (s *DDL) createIndex(idx DBIndex, comment string) error {
+	create := "CREATE INDEX "
+	if idx.Unique {
+		create = "CREATE UNIQUE INDEX "
+	}
+	create += fmt.Sprintf("%s ON %s (%s)", s.quote(idx.Name), s.quote(idx.Table), s.quoteAll(idx.Columns))
+	if s.script == nil {
+		return s.execSQL([]string{create})
+	}
+	drop := "DROP INDEX " + s.quote(idx.Name)
+	if s.Dialect() == DialectMySQL {
+		drop += " ON " + s.quote(idx.Table)
+	}
+	s.recordSQL([]string{"add " + comment}, []string{create}, []string{drop})
+	return nil
+}
+
+// checkRefUniques foreign keys whose referenced columns are a primary key or have an unique index, in db or declared by
+// tags if the table is not created yet. the others are reported as ErrRefNotUnique
+func (s *DDL) checkRefUniques(fks []ForeignKey) ([]ForeignKey, error) {
+	var r []ForeignKey
+	var errs MultiError
+	for _, fk := range fks {
+		dst := s.GetSchemaByStructName(fk.RefStruct)
+		if dst == nil || equalFoldStrs(fk.RefColumns, dst.PrimaryFieldDBNames) {
+			r = append(r, fk)
+			continue
+		}
+		var uniques [][]string
+		if s.db.Migrator().HasTable(fk.RefTable) {
+			existing, err := s.Indexes(fk.RefTable)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			for _, e := range existing {
+				if e.Unique {
+					uniques = append(uniques, e.Columns)
+				}
+			}
+		} else {
+			uniques = declaredUniques(dst)
+		}
+		found := false
+		for _, columns := range uniques {
+			if equalFoldStrs(lowerSorted(columns), lowerSorted(fk.RefColumns)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Errorf("%w: %s FK:%s.%s", ErrRefNotUnique, fk.Name, fk.RefStruct, strings.Join(fk.RefFields, ",")))
+			continue
+		}
+		r = append(r, fk)
+	}
+	return r, errs.ErrorOrNil()
+}
+
+// declaredUniques columns of unique fields and unique indexes declared by gorm tags
+func declaredUniques(sch *schema.Schema) [][]string {
+	var r [][]string
+	for _, f := range sch.Fields {
+		if f.Unique {
+			r = append(r, []string{f.DBName})
+		}
+	}
+	for _, idx := range sch.ParseIndexes() {
+		if idx.Class != "UNIQUE" {
+			continue
+		}
+		var columns []string
+		for _, opt := range idx.Fields {
+			columns = append(columns, opt.DBName)
+		}
+		r = append(r, columns)
+	}
+	return r
+}
+
+//CheckIndexes find db indexes backing indexes of caches, a cache index without one makes every cache miss a full table scan.
+// missing indexes are created if create is true, unique cache indexes get unique db indexes. eg. at startup
+//	reports, err := ddl.CheckIndexes(false, users, projects)
+// err is MultiError of ErrIndexNotBacked for indexes still missing
+func (s *DDL) CheckIndexes(create bool, caches ...*TableCache) ([]IndexReport, error) {
+	var r []IndexReport
+	var errs MultiError
+	for _, c := range caches {
+		sch := c.GetSchema()
+		var existing []DBIndex
+		if s.db.Migrator().HasTable(sch.Table) {
+			var err error
+			if existing, err = s.Indexes(sch.Table); err != nil {
+				return r, err
+			}
+		}
+		for _, index := range c.Indexes {
+			report := IndexReport{Struct: c.structName, Table: sch.Table, Index: index}
+			for _, f := range index.Fields {
+				report.Columns = append(report.Columns, sch.LookUpField(f).DBName)
+			}
+			for _, e := range existing {
+				if e.Covers(report.Columns) {
+					report.DBIndex = e.Name
+					break
+				}
+			}
+			if report.DBIndex == "" && create {
+				idx := DBIndex{Name: s.MakeIndexName(sch.Table, report.Columns), Table: sch.Table, Columns: report.Columns, Unique: index.IsUnique()}
+				comment := fmt.Sprintf("%s: %s.%s", idx.Name, c.structName, index)
+				if err := s.createIndex(idx, comment); err != nil {
+					errs = append(errs, fmt.Errorf("create index %s: %w", idx.Name, err))
+				} else {
+					report.DBIndex = idx.Name
+					report.Created = true
+					existing = append(existing, idx)
+				}
+			}
+			if report.DBIndex == "" {
+				errs = append(errs, fmt.Errorf("%w: %s", ErrIndexNotBacked, report))
+			}
+			r = append(r, report)
+		}
+	}
+	return r, errs.ErrorOrNil()
+}
+
+// CheckIndexes check db indexes backing indexes of this cache, see DDL.CheckIndexes
+func (s *TableCache) CheckIndexes(ctx context.Context, create bool) ([]IndexReport, error) {
+	return NewDDL(s.db.WithContext(ctx)).CheckIndexes(create, s)
+}
@@ -0,0 +1,207 @@
+package tablecache
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm/schema"
+)
+
+type PlanOpKind string
+
+const (
+	PlanDrop    PlanOpKind = "drop"
+	PlanReplace PlanOpKind = "replace"
+	PlanAdd     PlanOpKind = "add"
+)
+
+// PlanOp one change of foreign keys. Old is nil for add, New is nil for drop
+type PlanOp struct {
+	Kind PlanOpKind
+	Old  *ForeignKey
+	New  *ForeignKey
+}
+
+func (s PlanOp) Table() string {
+	if s.New != nil {
+		return s.New.Table
+	}
+	return s.Old.Table
+}
+
+func (s PlanOp) String() string {
+	switch s.Kind {
+	case PlanDrop:
+		return fmt.Sprintf("drop %s.%s", s.Old.Table, s.Old.Name)
+	case PlanReplace:
+		return fmt.Sprintf("replace %s.%s with %s", s.Old.Table, s.Old.Name, s.New.Name)
+	}
+	return fmt.Sprintf("add %s.%s", s.New.Table, s.New.Name)
+}
+
+// Plan changes to make foreign keys in db match FK tags. drops first, then replaces, then adds
+type Plan struct {
+	Ops []PlanOp
+	ddl *DDL
+}
+
+func (s *Plan) Empty() bool {
+	return len(s.Ops) == 0
+}
+
+// Tables tables changed by plan, in order of first op
+func (s *Plan) Tables() []string {
+	var r []string
+	seen := make(map[string]bool)
+	for _, op := range s.Ops {
+		if t := op.Table(); !seen[t] {
+			seen[t] = true
+			r = append(r, t)
+		}
+	}
+	return r
+}
+
+// SQL statements of plan, for review (dry run). sqlite tables are rebuilt once per table
+func (s *Plan) SQL() ([]string, error) {
+	var r []string
+	for _, table := range s.Tables() {
+		var drops, adds []ForeignKey
+		for _, op := range s.Ops {
+			if op.Table() != table {
+				continue
+			}
+			if op.Old != nil {
+				drops = append(drops, *op.Old)
+			}
+			if op.New != nil {
+				adds = append(adds, *op.New)
+			}
+		}
+		stmts, err := s.ddl.alterFKsSQL(table, drops, adds)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, stmts...)
+	}
+	return r, nil
+}
+
+// Plan compare foreign keys declared by FK tags with existing ones in db catalog, for tables added by AddTables.
+// existing foreign keys which no tag declares are dropped if they are named by MakeFKName. constraints of gorm associations
+// and foreign keys named otherwise are kept
+func (s *DDL) Plan() (*Plan, error) {
+	declared, err := s.DeclaredFKs()
+	if err != nil {
+		return nil, err
+	}
+	return s.plan(declared, true)
+}
+
+// plan declared foreign keys against db, drop undeclared ones if withDrops
+func (s *DDL) plan(declared []ForeignKey, withDrops bool) (*Plan, error) {
+	var err error
+	r := &Plan{ddl: s}
+	var tables []string
+	s.Range(func(structType reflect.Type, tableSchema *schema.Schema) bool {
+		tables = append(tables, tableSchema.Table)
+		return true
+	})
+	sort.Strings(tables)
+	associations := s.associationFKs()
+	var drops, replaces, adds []PlanOp
+	for _, table := range tables {
+		var existing []ForeignKey
+		if s.db.Migrator().HasTable(table) {
+			existing, err = s.ForeignKeys(table)
+			if err != nil {
+				return nil, err
+			}
+		}
+		used := make([]bool, len(existing))
+		for i := range declared {
+			d := declared[i]
+			if !strings.EqualFold(d.Table, table) {
+				continue
+			}
+			// prefer the key of the same name, gorm may have created another one on the same columns
+			found := -1
+			for j, e := range existing {
+				if !used[j] && e.SameName(d) {
+					found = j
+					break
+				}
+			}
+			for j, e := range existing {
+				if found < 0 && !used[j] && e.SameTarget(d) {
+					found = j
+				}
+			}
+			if found < 0 {
+				adds = append(adds, PlanOp{Kind: PlanAdd, New: &d})
+				continue
+			}
+			used[found] = true
+			// a key on the same columns under another name, eg. gorm's fk_members_team, is adopted. renaming it would
+			// leave two keys once AutoMigrate re-creates the original
+			d.Name = existing[found].Name
+			if !s.sameFK(existing[found], d) {
+				replaces = append(replaces, PlanOp{Kind: PlanReplace, Old: &existing[found], New: &d})
+			}
+		}
+		for j := range existing {
+			if withDrops && !used[j] && s.droppable(existing[j], associations) {
+				drops = append(drops, PlanOp{Kind: PlanDrop, Old: &existing[j]})
+			}
+		}
+	}
+	r.Ops = append(append(drops, replaces...), adds...)
+	return r, nil
+}
+
+// associationFKs names of constraints gorm creates for associations of added tables, as table.name in lower case
+func (s *DDL) associationFKs() map[string]bool {
+	r := make(map[string]bool)
+	s.Range(func(structType reflect.Type, tableSchema *schema.Schema) bool {
+		for _, rel := range tableSchema.Relationships.Relations {
+			if c := rel.ParseConstraint(); c != nil && c.Schema != nil {
+				r[strings.ToLower(c.Schema.Table+"."+c.Name)] = true
+			}
+		}
+		return true
+	})
+	return r
+}
+
+// droppable undeclared foreign key named by MakeFKName, which is not a constraint of gorm associations
+func (s *DDL) droppable(fk ForeignKey, associations map[string]bool) bool {
+	if len(fk.Columns) == 0 || len(fk.RefColumns) == 0 || associations[strings.ToLower(fk.Table+"."+fk.Name)] {
+		return false
+	}
+	return strings.EqualFold(fk.Name, s.MakeFKName(fk.Table, fk.Columns[0], fk.RefTable, fk.RefColumns[0]))
+}
+
+// Apply run statements of plan table by table, or record them in script mode
+func (s *DDL) Apply(plan *Plan) error {
+	var errs MultiError
+	for _, table := range plan.Tables() {
+		var drops, adds []ForeignKey
+		for _, op := range plan.Ops {
+			if op.Table() != table {
+				continue
+			}
+			if op.Old != nil {
+				drops = append(drops, *op.Old)
+			}
+			if op.New != nil {
+				adds = append(adds, *op.New)
+			}
+		}
+		if err := s.change(table, drops, adds); err != nil {
+			errs = append(errs, fmt.Errorf("apply plan on %s: %w", table, err))
+		}
+	}
+	return errs.ErrorOrNil()
+}
@@ -0,0 +1,176 @@
+package tablecache
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Statement sql with header comments
+type Statement struct {
+	Comments []string
+	SQL      string
+}
+
+func (s Statement) String() string {
+	var b strings.Builder
+	for _, c := range s.Comments {
+		b.WriteString("-- " + c + "\n")
+	}
+	b.WriteString(s.SQL + ";\n")
+	return b.String()
+}
+
+// DDLScript statements recorded by DDL in script mode, with statements rolling them back
+type DDLScript struct {
+	Statements []Statement
+	Rollback   []Statement       // in order to run, last change is rolled back first
+	tables     map[string]string // sqlite table: CREATE TABLE sql after recorded statements
+}
+
+// SQL recorded statements without comments
+func (s *DDLScript) SQL() []string {
+	return statementsSQL(s.Statements)
+}
+
+// RollbackSQL rollback statements without comments
+func (s *DDLScript) RollbackSQL() []string {
+	return statementsSQL(s.Rollback)
+}
+
+func statementsSQL(stmts []Statement) []string {
+	r := make([]string, len(stmts))
+	for i, v := range stmts {
+		r[i] = v.SQL
+	}
+	return r
+}
+
+// WriteTo write statements with comments, eg. into a migration file
+func (s *DDLScript) WriteTo(w io.Writer) (int64, error) {
+	return writeStatements(w, s.Statements)
+}
+
+// WriteRollbackTo write rollback statements with comments
+func (s *DDLScript) WriteRollbackTo(w io.Writer) (int64, error) {
+	return writeStatements(w, s.Rollback)
+}
+
+func writeStatements(w io.Writer, stmts []Statement) (int64, error) {
+	var r int64
+	for _, v := range stmts {
+		n, err := io.WriteString(w, v.String())
+		r += int64(n)
+		if err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+//Script record statements of DDL operations in f instead of running them. catalog is still read to skip existing constraints. eg.
+//	script, err := ddl.Script(ddl.MakeFKs)
+//	script.WriteTo(migrationFile)
+//	script.WriteRollbackTo(rollbackFile)
+// DDL should not be used concurrently while scripting
+func (s *DDL) Script(f func() error) (*DDLScript, error) {
+	s.script = &DDLScript{tables: make(map[string]string)}
+	defer func() {
+		s.script = nil
+	}()
+	err := f()
+	return s.script, err
+}
+
+// change drop and add foreign keys of table, or record statements in script mode
+func (s *DDL) change(table string, drops []ForeignKey, adds []ForeignKey) error {
+	if s.script == nil {
+		stmts, err := s.alterFKsSQL(table, drops, adds)
+		if err != nil {
+			return err
+		}
+		return s.execSQL(stmts)
+	}
+	// one statement per foreign key if possible, so that each carries its own comments
+	if s.Dialect() != DialectSQLite {
+		for _, fk := range drops {
+			if err := s.record(table, []ForeignKey{fk}, nil); err != nil {
+				return err
+			}
+		}
+		for _, fk := range adds {
+			if err := s.record(table, nil, []ForeignKey{fk}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return s.record(table, drops, adds)
+}
+
+// record statements of change and their rollback into script
+func (s *DDL) record(table string, drops []ForeignKey, adds []ForeignKey) error {
+	stmts, createSQL, err := s.alterFKs(table, drops, adds)
+	if err != nil {
+		return err
+	}
+	s.pendTable(table, createSQL)
+	rollback, _, err := s.alterFKs(table, adds, drops)
+	if err != nil {
+		return err
+	}
+	var comments []string
+	for _, fk := range drops {
+		comments = append(comments, "drop "+fkComment(fk))
+	}
+	for _, fk := range adds {
+		comments = append(comments, "add "+fkComment(fk))
+	}
+	s.recordSQL(comments, stmts, rollback)
+	return nil
+}
+
+// pendTable CREATE TABLE sql of sqlite table rebuilt by recorded statements, rollback statements are made against it
+func (s *DDL) pendTable(table string, createSQL string) {
+	if createSQL != "" {
+		s.script.tables[table] = createSQL
+	}
+}
+
+// recordSQL add statements to script and rollback statements before earlier ones
+func (s *DDL) recordSQL(comments []string, stmts []string, rollback []string) {
+	s.script.Statements = append(s.script.Statements, toStatements(comments, stmts)...)
+	rollbackComments := make([]string, len(comments))
+	for i, c := range comments {
+		rollbackComments[i] = "rollback: " + c
+	}
+	s.script.Rollback = append(toStatements(rollbackComments, rollback), s.script.Rollback...)
+}
+
+// toStatements comments go to the first statement
+func toStatements(comments []string, stmts []string) []Statement {
+	r := make([]Statement, len(stmts))
+	for i, v := range stmts {
+		r[i].SQL = v
+	}
+	if len(r) > 0 {
+		r[0].Comments = comments
+	}
+	return r
+}
+
+// fkComment eg. fk_tasks_project_id: Task.ProjectID -> projects(id) ON DELETE CASCADE ON UPDATE CASCADE
+func fkComment(fk ForeignKey) string {
+	source := fk.Table + "(" + strings.Join(fk.Columns, ", ") + ")"
+	if fk.Struct != "" {
+		source = fk.Struct + "." + strings.Join(fk.Fields, ", ")
+	}
+	r := fmt.Sprintf("%s: %s -> %s(%s)", fk.Name, source, fk.RefTable, strings.Join(fk.RefColumns, ", "))
+	if fk.OnDelete != FKEmpty {
+		r += " ON DELETE " + string(fk.OnDelete)
+	}
+	if fk.OnUpdate != FKEmpty {
+		r += " ON UPDATE " + string(fk.OnUpdate)
+	}
+	return r
+}
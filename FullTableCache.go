@@ -3,6 +3,8 @@ package tablecache
 import (
 	"errors"
 	"reflect"
+	"sync"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
@@ -10,13 +12,23 @@ import (
 
 type FullTableCache struct {
 	*RedisGorm
-	key string
+	key         string
+	lockTTL     time.Duration
+	loadWait    time.Duration
+	incremental *IncrementalOptions
+	syncCount   int64 // atomic
+	snapshot    *snapshot
+	lookup      *snapshot
+	lookupOnce  sync.Once
+	indexFields []string
 }
 
 func NewFullTableCache(redisGorm *RedisGorm, key string) *FullTableCache {
 	return &FullTableCache{
 		RedisGorm: redisGorm,
 		key:       key,
+		lockTTL:   DefaultLoadLockTTL,
+		loadWait:  DefaultLoadWait,
 	}
 }
 
@@ -42,67 +54,72 @@ func (s *FullTableCache) Exist() (bool, error) {
 	return c > 0, err
 }
 
-func (s *FullTableCache) ensureLoaded() error {
+// ensureLoaded return false if cache is not available now (eg. another instance is loading), caller should read db directly
+func (s *FullTableCache) ensureLoaded() (bool, error) {
 	ok, err := s.Exist()
-	if err != nil {
-		return err
-	}
-	if !ok {
-		return s.Load()
-	}
-	return nil
-}
-
-func (s *FullTableCache) Load() error {
-	records := s.FactoryListRef()
-	tx := s.db.Find(records)
-	if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
-		return tx.Error
+	if err != nil || ok {
+		return ok, err
 	}
-	kvs, err := s.encode(records)
+	err = s.Load()
 	if err != nil {
-		return err
+		return false, err
 	}
-	s.redisClient.HSet(s.redisCtx, s.key, kvs)
-	if s.ttl > 0 {
-		err := s.redisClient.Expire(s.redisCtx, s.key, s.ttl).Err()
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	return s.Exist()
 }
 
 //All return all record from cache. type is: *[]Table
 func (s *FullTableCache) All() (interface{}, error) {
+	if s.snapshot != nil && s.snapshot.ready() {
+		return s.snapshot.all(s.FactoryListRef()), nil
+	}
 	records := s.FactoryListRef()
-	err := s.ensureLoaded()
+	ok, err := s.ensureLoaded()
 	if err != nil {
 		return records, err
 	}
-	kvs, err := s.redisClient.HGetAll(s.redisCtx, s.key).Result()
-	if err != nil {
+	if !ok {
+		err = s.db.Find(records).Error
 		return records, err
 	}
-	if len(kvs) == 0 {
-		return records, nil
+	raws, err := s.getAllRaw()
+	if err != nil {
+		return records, err
 	}
-	jsonStr := "["
-	for _, v := range kvs {
-		jsonStr += v + ","
+	rV := reflect.ValueOf(records).Elem()
+	for _, raw := range raws {
+		record := s.FactorySingleRef()
+		if err := s.marshaller.Unmarshal(record, raw); err != nil {
+			return records, err
+		}
+		rV.Set(reflect.Append(rV, reflect.ValueOf(record).Elem()))
 	}
-	jsonStr = jsonStr[0:len(jsonStr)-1] + "]"
-	err = s.marshaller.Unmarshal(records, jsonStr)
-	return records, err
+	return records, nil
+}
+
+// getAllRaw HGETALL the live hash in one command, so that a reload renaming the hash can not mix two versions
+func (s *FullTableCache) getAllRaw() (map[string]string, error) {
+	raws, err := s.redisClient.HGetAll(s.redisCtx, s.key).Result()
+	delete(raws, loadedField)
+	return raws, err
 }
 
 //Get record by id, type is *Table
 func (s *FullTableCache) Get(id interface{}) (interface{}, error) {
-	err := s.ensureLoaded()
+	if s.snapshot != nil && s.snapshot.ready() {
+		return s.snapshot.get(s.cacheUtil.Stringify(id)), nil
+	}
+	ok, err := s.ensureLoaded()
 	if err != nil {
 		return nil, err
 	}
 	record := s.FactorySingleRef()
+	if !ok {
+		err = s.db.Take(record, id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return record, err
+	}
 	jsonStr, err := s.redisClient.HGet(s.redisCtx, s.key, s.cacheUtil.Stringify(id)).Result()
 	if err == redis.Nil {
 		return nil, nil
@@ -119,7 +136,7 @@ func (s *FullTableCache) Create(valueRef interface{}) error {
 	if tx.Error != nil {
 		return tx.Error
 	}
-	return s.set(valueRef)
+	return s.setPersisted([]interface{}{valueRef})
 }
 
 func (s *FullTableCache) Save(valueRef interface{}) error {
@@ -127,9 +144,10 @@ func (s *FullTableCache) Save(valueRef interface{}) error {
 	if tx.Error != nil {
 		return tx.Error
 	}
-	return s.set(valueRef)
+	return s.setPersisted([]interface{}{valueRef})
 }
 
+//Update update fields of valueRef, all fields if no fields. valueRef is refreshed with the persisted row
 func (s *FullTableCache) Update(valueRef interface{}, fields ...string) error {
 	var tx *gorm.DB
 	if len(fields) > 0 {
@@ -140,23 +158,39 @@ func (s *FullTableCache) Update(valueRef interface{}, fields ...string) error {
 	if tx.Error != nil {
 		return tx.Error
 	}
-	return s.set(valueRef)
+	return s.setPersisted([]interface{}{valueRef})
 }
-func (s *FullTableCache) set(valueRef interface{}) error {
-	err := s.ensureLoaded()
-	if err != nil {
-		return err
+
+// hset write records into the live hash and the hash being loaded, in chunks. a missing live hash will be loaded on next read
+func (s *FullTableCache) hset(valueRefs []interface{}) error {
+	var errs MultiError
+	keys := []string{s.key, s.getStagingKey(), s.getStagingDeletedKey()}
+	for start := 0; start < len(valueRefs); start += s.batchSize {
+		end := start + s.batchSize
+		if end > len(valueRefs) {
+			end = len(valueRefs)
+		}
+		args := make([]interface{}, 0, 2*(end-start))
+		for _, v := range valueRefs[start:end] {
+			jsonStr, err := s.marshaller.Marshal(v)
+			if err != nil {
+				return err
+			}
+			args = append(args, s.cacheUtil.Stringify(s.cacheUtil.GetFieldValue(v, s.idField)), jsonStr)
+		}
+		if err := fullSetScript.Run(s.redisCtx, s.redisClient, keys, args...).Err(); err != nil && err != redis.Nil {
+			errs = append(errs, err)
+		}
 	}
-	id := s.cacheUtil.GetFieldValue(valueRef, s.idField)
-	jsonStr, err := s.marshaller.Marshal(valueRef)
-	if err != nil {
-		return err
+	if len(valueRefs) > 0 {
+		if err := s.notifyChange(); err != nil {
+			errs = append(errs, err)
+		}
 	}
-	s.redisClient.HSet(s.redisCtx, s.key, s.cacheUtil.Stringify(id), jsonStr)
-	return nil
+	return errs.ErrorOrNil()
 }
 func (s *FullTableCache) stringifyIDs(ids interface{}) []string {
-	idsV := reflect.ValueOf(ids)
+	idsV := reflect.Indirect(reflect.ValueOf(ids))
 	n := idsV.Len()
 	r := make([]string, n)
 	for i := 0; i < n; i++ {
@@ -165,15 +199,43 @@ func (s *FullTableCache) stringifyIDs(ids interface{}) []string {
 	return r
 }
 
-//Delete by ids
+//Delete by ids, eg. Delete(1,2) or Delete([]uint64{1,2})
 func (s *FullTableCache) Delete(ids ...interface{}) error {
-	if len(ids) == 0 {
+	var args interface{} = ids
+	if len(ids) == 1 && isSlice(ids[0]) {
+		args = ids[0]
+	}
+	if reflect.Indirect(reflect.ValueOf(args)).Len() == 0 {
 		return nil
 	}
-	err := s.db.Delete(s.FactorySingleRef(), ids).Error
+	err := s.db.Delete(s.FactorySingleRef(), args).Error
 	if err != nil {
 		return err
 	}
-	s.redisClient.HDel(s.redisCtx, s.key, s.stringifyIDs(ids)...)
-	return nil
+	return s.hdel(s.stringifyIDs(args)...)
+}
+
+// hdel delete fields from the live hash and the hash being loaded, in chunks
+func (s *FullTableCache) hdel(fields ...string) error {
+	var errs MultiError
+	keys := []string{s.key, s.getStagingKey(), s.getStagingDeletedKey()}
+	for start := 0; start < len(fields); start += s.batchSize {
+		end := start + s.batchSize
+		if end > len(fields) {
+			end = len(fields)
+		}
+		args := make([]interface{}, end-start)
+		for i, v := range fields[start:end] {
+			args[i] = v
+		}
+		if err := fullDelScript.Run(s.redisCtx, s.redisClient, keys, args...).Err(); err != nil && err != redis.Nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(fields) > 0 {
+		if err := s.notifyChange(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.ErrorOrNil()
 }
@@ -0,0 +1,277 @@
+package tablecache
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// items *[]Table or []*Table -> [*Table]
+func itemRefs(sliceRef interface{}) []interface{} {
+	vs := reflect.Indirect(reflect.ValueOf(sliceRef))
+	r := make([]interface{}, vs.Len())
+	for i := range r {
+		item := vs.Index(i)
+		if item.Kind() != reflect.Ptr {
+			item = item.Addr()
+		}
+		r[i] = item.Interface()
+	}
+	return r
+}
+
+func (s *FullTableCache) idsOf(valueRefs []interface{}) []interface{} {
+	r := make([]interface{}, len(valueRefs))
+	for i, v := range valueRefs {
+		r[i] = s.cacheUtil.GetFieldValue(v, s.idField)
+	}
+	return r
+}
+
+// refetch read persisted rows by ids, for values computed by db
+func (s *FullTableCache) refetch(ids []interface{}) ([]interface{}, error) {
+	records := s.FactoryListRef()
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	idColumn := s.schema.LookUpField(s.idField).DBName
+	var errs MultiError
+	for start := 0; start < len(ids); start += s.batchSize {
+		end := start + s.batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batch := s.FactoryListRef()
+		if err := s.db.Where(idColumn+" IN ?", ids[start:end]).Find(batch).Error; err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		rV := reflect.ValueOf(records).Elem()
+		rV.Set(reflect.AppendSlice(rV, reflect.ValueOf(batch).Elem()))
+	}
+	return itemRefs(records), errs.ErrorOrNil()
+}
+
+// setPersisted re-read rows of valueRefs from db, copy them back into valueRefs and write them into cache.
+// the cache reflects db defaults, triggers and fields not selected by Update. rows deleted meanwhile are removed
+func (s *FullTableCache) setPersisted(valueRefs []interface{}) error {
+	ids := s.idsOf(valueRefs)
+	records, err := s.refetch(ids)
+	if err != nil {
+		return err
+	}
+	fresh := make(map[string]interface{}, len(records))
+	for _, v := range records {
+		fresh[s.cacheUtil.Stringify(s.cacheUtil.GetFieldValue(v, s.idField))] = v
+	}
+	var gone []string
+	for i, v := range valueRefs {
+		id := s.cacheUtil.Stringify(ids[i])
+		record, ok := fresh[id]
+		if !ok {
+			gone = append(gone, id)
+			continue
+		}
+		reflect.ValueOf(v).Elem().Set(reflect.ValueOf(record).Elem())
+	}
+	var errs MultiError
+	if err := s.hset(records); err != nil {
+		errs = append(errs, err)
+	}
+	if err := s.hdel(gone...); err != nil {
+		errs = append(errs, err)
+	}
+	return errs.ErrorOrNil()
+}
+
+//CreateMany insert records in batches, then write them into cache in one pipeline per batch. sliceRef eg. *[]Table
+func (s *FullTableCache) CreateMany(sliceRef interface{}) error {
+	err := s.db.CreateInBatches(sliceRef, s.batchSize).Error
+	if err != nil {
+		return err
+	}
+	return s.setPersisted(itemRefs(sliceRef))
+}
+
+//SaveMany save(insert or update all fields) records, sliceRef eg. *[]Table
+func (s *FullTableCache) SaveMany(sliceRef interface{}) error {
+	if reflect.Indirect(reflect.ValueOf(sliceRef)).Len() == 0 {
+		return nil
+	}
+	err := s.db.Save(sliceRef).Error
+	if err != nil {
+		return err
+	}
+	return s.setPersisted(itemRefs(sliceRef))
+}
+
+//Upsert insert records, on conflict do as onConflict, eg. Upsert(&plans, clause.OnConflict{UpdateAll: true}).
+//valueRef is *Table or *[]Table. rows are re-read from db and copied back into valueRef, since conflicting rows keep db values
+func (s *FullTableCache) Upsert(valueRef interface{}, onConflict clause.OnConflict) error {
+	refs := []interface{}{valueRef}
+	if isSlice(valueRef) {
+		refs = itemRefs(valueRef)
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+	presetIDs := make([]bool, len(refs))
+	for i, v := range refs {
+		presetIDs[i] = !reflect.ValueOf(s.cacheUtil.GetFieldValue(v, s.idField)).IsZero()
+	}
+	err := s.db.Clauses(onConflict).CreateInBatches(valueRef, s.batchSize).Error
+	if err != nil {
+		return err
+	}
+	records, err := s.refetchUpserted(refs, presetIDs, onConflict)
+	if err != nil {
+		return err
+	}
+	var fresh []interface{}
+	for i, record := range records {
+		if record == nil {
+			continue
+		}
+		reflect.ValueOf(refs[i]).Elem().Set(reflect.ValueOf(record).Elem())
+		fresh = append(fresh, record)
+	}
+	return s.hset(fresh)
+}
+
+// upsertKeys fields of the conflict target, or of unique indexes declared by tags if not given (eg. mysql), then the
+// primary key
+func (s *FullTableCache) upsertKeys(onConflict clause.OnConflict) [][]*schema.Field {
+	var columns [][]string
+	if len(onConflict.Columns) > 0 {
+		var target []string
+		for _, c := range onConflict.Columns {
+			target = append(target, c.Name)
+		}
+		columns = append(columns, target)
+	} else {
+		uniques := declaredUniques(s.schema)
+		sort.Slice(uniques, func(i, j int) bool {
+			return strings.Join(uniques[i], ",") < strings.Join(uniques[j], ",")
+		})
+		columns = append(columns, uniques...)
+	}
+	columns = append(columns, s.schema.PrimaryFieldDBNames)
+	var r [][]*schema.Field
+	for _, names := range columns {
+		var key []*schema.Field
+		for _, name := range names {
+			if f := s.schema.LookUpField(name); f != nil {
+				key = append(key, f)
+			}
+		}
+		if len(key) == len(names) && len(key) > 0 {
+			r = append(r, key)
+		}
+	}
+	return r
+}
+
+// upsertKeyValue values of key fields of v, false if any is nil
+func (s *FullTableCache) upsertKeyValue(v interface{}, key []*schema.Field) (map[string]interface{}, string, bool) {
+	cond := make(map[string]interface{}, len(key))
+	strs := make([]string, len(key))
+	for i, f := range key {
+		fv := s.cacheUtil.GetFieldValue(v, f.Name)
+		if rv := reflect.ValueOf(fv); !rv.IsValid() || rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return nil, "", false
+		}
+		cond[f.DBName] = fv
+		strs[i] = s.cacheUtil.StringifyDeref(fv)
+	}
+	return cond, strings.Join(strs, ":"), true
+}
+
+// refetchUpserted re-read upserted rows by the conflict target, ids of conflicting rows may be missing or wrong
+// (eg. mysql ON DUPLICATE KEY UPDATE). rows are matched to refs by key values, nil for refs not found
+func (s *FullTableCache) refetchUpserted(refs []interface{}, presetIDs []bool, onConflict clause.OnConflict) ([]interface{}, error) {
+	r := make([]interface{}, len(refs))
+	keys := s.upsertKeys(onConflict)
+	for k, key := range keys {
+		primary := k == len(keys)-1
+		var pending []int
+		for i := range refs {
+			// ids assigned by the insert are only trusted when nothing else identifies the row
+			if r[i] == nil && (!primary || presetIDs[i] || len(keys) == 1) {
+				pending = append(pending, i)
+			}
+		}
+		for start := 0; start < len(pending); start += s.batchSize {
+			end := start + s.batchSize
+			if end > len(pending) {
+				end = len(pending)
+			}
+			var tx *gorm.DB
+			for _, i := range pending[start:end] {
+				cond, _, ok := s.upsertKeyValue(refs[i], key)
+				if !ok {
+					continue
+				}
+				if tx == nil {
+					tx = s.db.Where(cond)
+				} else {
+					tx = tx.Or(cond)
+				}
+			}
+			if tx == nil {
+				continue
+			}
+			batch := s.FactoryListRef()
+			if err := s.db.Where(tx).Find(batch).Error; err != nil {
+				return r, err
+			}
+			exact := make(map[string]interface{})
+			folded := make(map[string]interface{})
+			for _, record := range itemRefs(batch) {
+				_, str, _ := s.upsertKeyValue(record, key)
+				exact[str] = record
+				folded[strings.ToLower(str)] = record
+			}
+			for _, i := range pending[start:end] {
+				_, str, ok := s.upsertKeyValue(refs[i], key)
+				if !ok {
+					continue
+				}
+				if record, found := exact[str]; found {
+					r[i] = record
+				} else if record, found := folded[strings.ToLower(str)]; found {
+					// case insensitive collation
+					r[i] = record
+				}
+			}
+		}
+	}
+	return r, nil
+}
+
+//UpdateWhere update rows matching cond, eg. UpdateWhere(map[string]interface{}{"status": "trial"}, map[string]interface{}{"status": "expired"}).
+//affected rows are re-read from db and written into cache
+func (s *FullTableCache) UpdateWhere(cond interface{}, values interface{}) error {
+	idField := s.schema.LookUpField(s.idField)
+	ids := reflect.New(reflect.SliceOf(idField.FieldType)).Interface()
+	err := s.db.Model(s.FactorySingleRef()).Where(cond).Pluck(idField.DBName, ids).Error
+	if err != nil {
+		return err
+	}
+	idList := toInterfaces(ids)
+	if len(idList) == 0 {
+		return nil
+	}
+	err = s.db.Model(s.FactorySingleRef()).Where(idField.DBName+" IN ?", idList).Updates(values).Error
+	if err != nil {
+		return err
+	}
+	records, err := s.refetch(idList)
+	if err != nil {
+		return err
+	}
+	return s.hset(records)
+}
@@ -0,0 +1,79 @@
+package tablecache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+// scanRaw HSCAN the hash in chunks of batchSize, without blocking redis. f returns false to stop.
+// each id is visited once even if HSCAN returns it again. a reload during the scan may mix rows of both versions,
+// so it only backs Iterate; All, snapshots and SyncDeletes read the hash with one command
+func (s *FullTableCache) scanRaw(ctx context.Context, f func(id, raw string) (bool, error)) error {
+	seen := make(map[string]bool)
+	var cursor uint64
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		kvs, next, err := s.redisClient.HScan(ctx, s.key, cursor, "", int64(s.batchSize)).Result()
+		if err != nil {
+			return err
+		}
+		for i := 0; i+1 < len(kvs); i += 2 {
+			if seen[kvs[i]] || kvs[i] == loadedField {
+				continue
+			}
+			seen[kvs[i]] = true
+			ok, err := f(kvs[i], kvs[i+1])
+			if err != nil || !ok {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+//Iterate visit records one by one, backed by HSCAN. f receives *Table, returns false to stop.
+//it is not a consistent view, rows written or reloaded during the iteration may or may not be visited
+func (s *FullTableCache) Iterate(ctx context.Context, f func(record interface{}) bool) error {
+	ok, err := s.ensureLoaded()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return s.iterateDB(ctx, f)
+	}
+	return s.scanRaw(ctx, func(id, raw string) (bool, error) {
+		record := s.FactorySingleRef()
+		if err := s.marshaller.Unmarshal(record, raw); err != nil {
+			return false, err
+		}
+		return f(record), nil
+	})
+}
+
+var errStopIterate = errors.New("stop iterate")
+
+// iterateDB used when cache is being loaded by another instance
+func (s *FullTableCache) iterateDB(ctx context.Context, f func(record interface{}) bool) error {
+	records := s.FactoryListRef()
+	err := s.db.WithContext(ctx).FindInBatches(records, s.batchSize, func(tx *gorm.DB, batch int) error {
+		rV := reflect.ValueOf(records).Elem()
+		for i := 0; i < rV.Len(); i++ {
+			if !f(rV.Index(i).Addr().Interface()) {
+				return errStopIterate
+			}
+		}
+		return nil
+	}).Error
+	if errors.Is(err, errStopIterate) {
+		return nil
+	}
+	return err
+}
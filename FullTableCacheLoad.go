@@ -0,0 +1,243 @@
+package tablecache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultLoadLockTTL = 30 * time.Second
+	DefaultLoadWait    = 5 * time.Second
+	loadPollInterval   = 20 * time.Millisecond
+	// placeholder field, make sure the staging hash exists during loading
+	loadingField = "__loading__"
+	// placeholder field, make sure the live hash exists after loading, even for an empty table
+	loadedField = "__loaded__"
+)
+
+var ErrLoadInProgress = errors.New("full table cache is being loaded by another instance")
+var ErrLoadExpired = errors.New("full table cache staging hash expired before publish, load lock ttl is shorter than the load")
+
+// KEYS: live, staging, staging deleted set. ARGV: field1, value1, field2, value2 ...
+var fullSetScript = redis.NewScript(`
+local live = redis.call('EXISTS', KEYS[1]) == 1
+local loading = redis.call('EXISTS', KEYS[2]) == 1
+for i = 1, #ARGV, 2 do
+	if live then
+		redis.call('HSET', KEYS[1], ARGV[i], ARGV[i + 1])
+	end
+	if loading then
+		redis.call('HSET', KEYS[2], ARGV[i], ARGV[i + 1])
+		redis.call('SREM', KEYS[3], ARGV[i])
+	end
+end
+return 1
+`)
+
+// KEYS: live, staging, staging deleted set. ARGV: fields
+var fullDelScript = redis.NewScript(`
+local live = redis.call('EXISTS', KEYS[1]) == 1
+local loading = redis.call('EXISTS', KEYS[2]) == 1
+for _, field in ipairs(ARGV) do
+	if live then
+		redis.call('HDEL', KEYS[1], field)
+	end
+	if loading then
+		redis.call('HDEL', KEYS[2], field)
+		redis.call('SADD', KEYS[3], field)
+	end
+end
+return 1
+`)
+
+// KEYS: live, staging, staging deleted set, watermark. ARGV: ttl in milliseconds, loading field, watermark, loaded field
+var fullPublishScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[2]) == 0 then
+	-- staging expired, leave loading to the next reader
+	redis.call('DEL', KEYS[1], KEYS[3])
+	return 0
+end
+for _, field in ipairs(redis.call('SMEMBERS', KEYS[3])) do
+	redis.call('HDEL', KEYS[2], field)
+end
+redis.call('DEL', KEYS[3])
+redis.call('HDEL', KEYS[2], ARGV[2])
+redis.call('HSET', KEYS[2], ARGV[4], 1)
+redis.call('RENAME', KEYS[2], KEYS[1])
+if tonumber(ARGV[1]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+else
+	redis.call('PERSIST', KEYS[1])
+end
+if ARGV[3] ~= '' then
+	redis.call('SET', KEYS[4], ARGV[3])
+	if tonumber(ARGV[1]) > 0 then
+		redis.call('PEXPIRE', KEYS[4], ARGV[1])
+	end
+end
+return 1
+`)
+
+// KEYS: lock. ARGV: token
+var unlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// KEYS: lock. ARGV: token, ttl in milliseconds
+var extendLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+//SetLoadLock set ttl of the distributed load lock, should be longer than a full load
+func (s *FullTableCache) SetLoadLock(lockTTL time.Duration) {
+	s.lockTTL = lockTTL
+}
+
+//SetLoadWait set how long readers wait for a load by another instance, then read db directly. 0 means never wait
+func (s *FullTableCache) SetLoadWait(loadWait time.Duration) {
+	s.loadWait = loadWait
+}
+
+func (s *FullTableCache) getStagingKey() string {
+	return s.key + "/loading"
+}
+func (s *FullTableCache) getStagingDeletedKey() string {
+	return s.key + "/loading/deleted"
+}
+func (s *FullTableCache) getLockKey() string {
+	return s.key + "/lock"
+}
+
+func (s *FullTableCache) lock() (string, bool, error) {
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		return "", false, err
+	}
+	token := hex.EncodeToString(bs)
+	ok, err := s.redisClient.SetNX(s.redisCtx, s.getLockKey(), token, s.lockTTL).Result()
+	return token, ok, err
+}
+
+func (s *FullTableCache) unlock(token string) error {
+	return unlockScript.Run(s.redisCtx, s.redisClient, []string{s.getLockKey()}, token).Err()
+}
+
+// waitUnlock wait until lock is released or timeout. return true if released
+func (s *FullTableCache) waitUnlock(timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		c, err := s.redisClient.Exists(s.redisCtx, s.getLockKey()).Result()
+		if err != nil || c == 0 {
+			return c == 0, err
+		}
+		if !time.Now().Before(deadline) {
+			return false, nil
+		}
+		time.Sleep(loadPollInterval)
+	}
+}
+
+//Load load whole table into cache. if another instance is loading, wait for it instead of loading again
+func (s *FullTableCache) Load() error {
+	token, ok, err := s.lock()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		_, err = s.waitUnlock(s.loadWait)
+		return err
+	}
+	defer s.unlock(token)
+	return s.load(token)
+}
+
+//Reload rebuild cache from db. if another instance is loading, wait for it and then reload
+func (s *FullTableCache) Reload() error {
+	deadline := time.Now().Add(s.lockTTL)
+	for {
+		token, ok, err := s.lock()
+		if err != nil {
+			return err
+		}
+		if ok {
+			defer s.unlock(token)
+			return s.load(token)
+		}
+		if !time.Now().Before(deadline) {
+			return ErrLoadInProgress
+		}
+		time.Sleep(loadPollInterval)
+	}
+}
+
+// load build the hash in staging key, then rename it over the live key atomically.
+// writes during loading go to both keys, see fullSetScript and fullDelScript
+func (s *FullTableCache) load(token string) error {
+	staging := s.getStagingKey()
+	_, err := s.redisClient.TxPipelined(s.redisCtx, func(pipe redis.Pipeliner) error {
+		pipe.Del(s.redisCtx, staging, s.getStagingDeletedKey())
+		pipe.HSet(s.redisCtx, staging, loadingField, 1)
+		pipe.Expire(s.redisCtx, staging, s.lockTTL)
+		pipe.Expire(s.redisCtx, s.getStagingDeletedKey(), s.lockTTL)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// read db in batches, write each batch with HSETNX: records written by set() during loading are newer
+	var watermark time.Time
+	hasWatermark := false
+	records := s.FactoryListRef()
+	tx := s.db.FindInBatches(records, s.batchSize, func(tx *gorm.DB, batch int) error {
+		kvs, err := s.encode(records)
+		if err != nil {
+			return err
+		}
+		if s.incremental != nil {
+			if t, ok := s.maxTime(records, s.incremental.UpdatedAtField); ok && (!hasWatermark || t.After(watermark)) {
+				watermark, hasWatermark = t, true
+			}
+		}
+		_, err = s.redisClient.Pipelined(s.redisCtx, func(pipe redis.Pipeliner) error {
+			for k, v := range kvs {
+				pipe.HSetNX(s.redisCtx, staging, k, v)
+			}
+			// keep staging and lock alive for long loads
+			pipe.Expire(s.redisCtx, staging, s.lockTTL)
+			pipe.Expire(s.redisCtx, s.getStagingDeletedKey(), s.lockTTL)
+			extendLockScript.Eval(s.redisCtx, pipe, []string{s.getLockKey()}, token, s.lockTTL.Milliseconds())
+			return nil
+		})
+		return err
+	})
+	if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		s.redisClient.Del(s.redisCtx, staging)
+		return tx.Error
+	}
+	watermarkStr := ""
+	if hasWatermark {
+		watermarkStr = formatWatermark(watermark)
+	}
+	keys := []string{s.key, staging, s.getStagingDeletedKey(), s.getWatermarkKey()}
+	published, err := fullPublishScript.Run(s.redisCtx, s.redisClient, keys, s.ttl.Milliseconds(), loadingField, watermarkStr, loadedField).Int()
+	if err != nil {
+		return err
+	}
+	if published == 0 {
+		return ErrLoadExpired
+	}
+	// not notifyChange, Load runs inside snapshot refreshes
+	_, err = s.publishChange()
+	return err
+}
@@ -0,0 +1,89 @@
+package tablecache
+
+import "strings"
+
+//AddIndex declare secondary indexes, eg. AddIndex("Code","Status"). each field is indexed separately, in memory
+func (s *FullTableCache) AddIndex(fields ...string) {
+	s.checkFields(fields...)
+	s.indexFields = append(s.indexFields, fields...)
+	// rebuild snapshots with new indexes on next refresh
+	for _, snap := range []*snapshot{s.snapshot, s.lookup} {
+		if snap != nil {
+			snap.mu.Lock()
+			snap.loaded = false
+			snap.mu.Unlock()
+		}
+	}
+}
+
+// lookupSnapshot the snapshot kept by EnableSnapshot, or a local one refreshed when version changes
+func (s *FullTableCache) lookupSnapshot() (*snapshot, error) {
+	if s.snapshot != nil && s.snapshot.ready() {
+		return s.snapshot, nil
+	}
+	s.lookupOnce.Do(func() {
+		s.lookup = &snapshot{version: -1}
+	})
+	err := s.refresh(s.lookup)
+	if err != nil {
+		return nil, err
+	}
+	if !s.lookup.ready() {
+		// another instance is loading
+		return nil, nil
+	}
+	return s.lookup, nil
+}
+
+func (s *FullTableCache) indexField(field string) (string, error) {
+	for _, v := range s.indexFields {
+		if strings.EqualFold(v, field) {
+			return v, nil
+		}
+	}
+	return "", indexNotDeclared([]string{field})
+}
+
+//GetBy get first record by secondary index, eg. GetBy("Code","CN"). type is *Table, nil if not found
+func (s *FullTableCache) GetBy(field string, value interface{}) (interface{}, error) {
+	records, err := s.ListBy(field, value)
+	if err != nil {
+		return nil, err
+	}
+	return firstRecord(records), nil
+}
+
+//ListBy list records by secondary index, eg. ListBy("Status","active"). type is *[]Table
+func (s *FullTableCache) ListBy(field string, value interface{}) (interface{}, error) {
+	field, err := s.indexField(field)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := s.lookupSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	if snap == nil {
+		records := s.FactoryListRef()
+		err = s.db.Where(s.toColumns(map[string]interface{}{field: value})).Find(records).Error
+		return records, err
+	}
+	ids, _ := snap.lookup(field, s.cacheUtil.Stringify(value))
+	return snap.list(s.FactoryListRef(), ids), nil
+}
+
+//Filter list records matching f, eg. Filter(func(r interface{}) bool { return r.(*Plan).Active }). type is *[]Table
+func (s *FullTableCache) Filter(f func(record interface{}) bool) (interface{}, error) {
+	snap, err := s.lookupSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	if snap == nil {
+		all, err := s.All()
+		if err != nil {
+			return nil, err
+		}
+		return filterList(s.FactoryListRef(), all, f), nil
+	}
+	return snap.filter(s.FactoryListRef(), f), nil
+}
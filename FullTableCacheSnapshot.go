@@ -0,0 +1,299 @@
+package tablecache
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// snapshot process local copy of the whole table, decoded
+type snapshot struct {
+	mu        sync.RWMutex
+	refreshMu sync.Mutex
+	loaded    bool
+	stale     bool // local writes are not in the snapshot yet, reads go to redis
+	version   int64
+	raws      map[string]string      // id: json
+	records   map[string]interface{} // id: *Table
+	ids       []string
+	indexes   map[string]map[string][]string // field: {value: [id1,id2]}
+	listeners []func(old, new interface{})
+}
+
+func (s *snapshot) ready() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.loaded && !s.stale
+}
+
+// all copy records into listRef
+func (s *snapshot) all(listRef interface{}) interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rV := reflect.ValueOf(listRef).Elem()
+	for _, id := range s.ids {
+		rV.Set(reflect.Append(rV, reflect.ValueOf(s.records[id]).Elem()))
+	}
+	return listRef
+}
+
+// get copy of record
+func (s *snapshot) get(id string) interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return copyRecord(s.records[id])
+}
+
+// copyRecord shallow copy *Table, nil if not found
+func copyRecord(record interface{}) interface{} {
+	if record == nil {
+		return nil
+	}
+	r := reflect.New(reflect.TypeOf(record).Elem())
+	r.Elem().Set(reflect.ValueOf(record).Elem())
+	return r.Interface()
+}
+
+// lookup ids by index value, ok is false if field is not indexed
+func (s *snapshot) lookup(field, value string) ([]string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	index, ok := s.indexes[field]
+	if !ok {
+		return nil, false
+	}
+	return index[value], true
+}
+
+// list copy records of ids into listRef
+func (s *snapshot) list(listRef interface{}, ids []string) interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rV := reflect.ValueOf(listRef).Elem()
+	for _, id := range ids {
+		if record, ok := s.records[id]; ok {
+			rV.Set(reflect.Append(rV, reflect.ValueOf(record).Elem()))
+		}
+	}
+	return listRef
+}
+
+// filter copy records matching f into listRef. f gets a copy, it must not modify the snapshot
+func (s *snapshot) filter(listRef interface{}, f func(record interface{}) bool) interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rV := reflect.ValueOf(listRef).Elem()
+	for _, id := range s.ids {
+		if record := copyRecord(s.records[id]); f(record) {
+			rV.Set(reflect.Append(rV, reflect.ValueOf(record).Elem()))
+		}
+	}
+	return listRef
+}
+
+func (s *FullTableCache) getVersionKey() string {
+	return s.key + "/version"
+}
+
+func (s *FullTableCache) getChangesChannel() string {
+	return s.key + "/changes"
+}
+
+// publishChange bump version and publish it, so that snapshots of all instances refresh
+func (s *FullTableCache) publishChange() (int64, error) {
+	version, err := s.redisClient.Incr(s.redisCtx, s.getVersionKey()).Result()
+	if err != nil {
+		return 0, err
+	}
+	return version, s.redisClient.Publish(s.redisCtx, s.getChangesChannel(), version).Err()
+}
+
+// notifyChange publish a write. the local snapshot is refreshed before returning, so that the writer reads its own writes
+func (s *FullTableCache) notifyChange() error {
+	version, err := s.publishChange()
+	if version == 0 {
+		s.markStale(s.snapshot, s.lookup)
+		return err
+	}
+	var errs MultiError
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if s.snapshot != nil {
+		if err := s.refresh(s.snapshot); err != nil {
+			errs = append(errs, err)
+		}
+		s.snapshot.mu.Lock()
+		if s.snapshot.version < version {
+			// refresh failed or another instance is loading
+			s.snapshot.stale = true
+		}
+		s.snapshot.mu.Unlock()
+	}
+	return errs.ErrorOrNil()
+}
+
+// markStale make snapshots serve reads from redis until their next refresh
+func (s *FullTableCache) markStale(snaps ...*snapshot) {
+	for _, snap := range snaps {
+		if snap != nil {
+			snap.mu.Lock()
+			snap.stale = true
+			snap.mu.Unlock()
+		}
+	}
+}
+
+//EnableSnapshot serve All and Get from a process local snapshot, which is refreshed on version bumps published by writers.
+//checkInterval is the period of version check in case of lost messages, 0 to disable. background errors are sent to onError if not nil
+func (s *FullTableCache) EnableSnapshot(ctx context.Context, checkInterval time.Duration, onError func(error)) error {
+	s.snapshot = &snapshot{version: -1}
+	// subscribe before the first refresh, no change will be missed
+	pubsub := s.redisClient.Subscribe(ctx, s.getChangesChannel())
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return err
+	}
+	if err := s.RefreshSnapshot(); err != nil {
+		pubsub.Close()
+		return err
+	}
+	go func() {
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		var tick <-chan time.Time
+		if checkInterval > 0 {
+			ticker := time.NewTicker(checkInterval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-messages:
+				if !ok {
+					return
+				}
+			case <-tick:
+			}
+			if err := s.RefreshSnapshot(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}()
+	return nil
+}
+
+//OnChange subscribe changes of snapshot rows. old is nil for created rows, new is nil for deleted rows
+func (s *FullTableCache) OnChange(f func(old, new interface{})) {
+	if s.snapshot == nil {
+		panic("OnChange requires EnableSnapshot")
+	}
+	s.snapshot.mu.Lock()
+	defer s.snapshot.mu.Unlock()
+	s.snapshot.listeners = append(s.snapshot.listeners, f)
+}
+
+//RefreshSnapshot reload snapshot from redis if version changed
+func (s *FullTableCache) RefreshSnapshot() error {
+	if s.snapshot == nil {
+		return nil
+	}
+	return s.refresh(s.snapshot)
+}
+
+func (s *FullTableCache) refresh(snap *snapshot) error {
+	snap.refreshMu.Lock()
+	defer snap.refreshMu.Unlock()
+	version, err := s.redisClient.Get(s.redisCtx, s.getVersionKey()).Int64()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	snap.mu.RLock()
+	unchanged := snap.loaded && !snap.stale && version == snap.version
+	snap.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	ok, err := s.ensureLoaded()
+	if err != nil || !ok {
+		// another instance is loading, it will publish a new version when done
+		return err
+	}
+	raws, err := s.getAllRaw()
+	if err != nil {
+		return err
+	}
+	records := make(map[string]interface{}, len(raws))
+	ids := make([]string, 0, len(raws))
+	for id, raw := range raws {
+		record := s.FactorySingleRef()
+		if err := s.marshaller.Unmarshal(record, raw); err != nil {
+			return err
+		}
+		records[id] = record
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	indexes := make(map[string]map[string][]string, len(s.indexFields))
+	for _, field := range s.indexFields {
+		index := make(map[string][]string)
+		for _, id := range ids {
+			value := s.cacheUtil.Stringify(s.cacheUtil.GetFieldValue(records[id], field))
+			index[value] = append(index[value], id)
+		}
+		indexes[field] = index
+	}
+	snap.mu.Lock()
+	oldRaws, oldRecords, listeners, wasLoaded := snap.raws, snap.records, snap.listeners, snap.loaded
+	snap.raws, snap.records, snap.ids, snap.indexes, snap.version, snap.loaded, snap.stale = raws, records, ids, indexes, version, true, false
+	snap.mu.Unlock()
+	if !wasLoaded || len(listeners) == 0 {
+		return nil
+	}
+	for _, id := range ids {
+		if oldRaw, ok := oldRaws[id]; !ok {
+			notify(listeners, nil, records[id])
+		} else if oldRaw != raws[id] {
+			notify(listeners, oldRecords[id], records[id])
+		}
+	}
+	for id := range oldRaws {
+		if _, ok := raws[id]; !ok {
+			notify(listeners, oldRecords[id], nil)
+		}
+	}
+	return nil
+}
+
+func notify(listeners []func(old, new interface{}), old, new interface{}) {
+	for _, f := range listeners {
+		f(old, new)
+	}
+}
+
+// firstRecord first item of *[]Table as *Table, nil if empty
+func firstRecord(listRef interface{}) interface{} {
+	rV := reflect.ValueOf(listRef).Elem()
+	if rV.Len() == 0 {
+		return nil
+	}
+	return rV.Index(0).Addr().Interface()
+}
+
+// filterList append items of src matching f into listRef
+func filterList(listRef, src interface{}, f func(record interface{}) bool) interface{} {
+	rV := reflect.ValueOf(listRef).Elem()
+	srcV := reflect.ValueOf(src).Elem()
+	for i := 0; i < srcV.Len(); i++ {
+		if f(srcV.Index(i).Addr().Interface()) {
+			rV.Set(reflect.Append(rV, srcV.Index(i)))
+		}
+	}
+	return listRef
+}
@@ -0,0 +1,206 @@
+package tablecache
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// IncrementalOptions sync changed rows only, by an UpdatedAt high-water mark
+type IncrementalOptions struct {
+	UpdatedAtField string        // eg. UpdatedAt
+	DeletedAtField string        // soft delete field, eg. DeletedAt. empty if rows are hard deleted
+	DiffEvery      int           // diff cached ids with db ids every N syncs to find hard deleted rows, 0 to disable
+	Overlap        time.Duration // re-read rows updated slightly before the watermark, for transactions committed late
+}
+
+// EnableIncremental make Sync fetch rows changed since last watermark instead of reloading whole table
+func (s *FullTableCache) EnableIncremental(options IncrementalOptions) {
+	fields := []string{options.UpdatedAtField}
+	if options.DeletedAtField != "" {
+		fields = append(fields, options.DeletedAtField)
+	}
+	s.checkFields(fields...)
+	s.incremental = &options
+}
+
+// watermark is stored alongside the hash, shared by all instances
+func (s *FullTableCache) getWatermarkKey() string {
+	return s.key + "/watermark"
+}
+
+func formatWatermark(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func (s *FullTableCache) getWatermark() (time.Time, bool, error) {
+	str, err := s.redisClient.Get(s.redisCtx, s.getWatermarkKey()).Result()
+	if err == redis.Nil {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, str)
+	return t, err == nil, err
+}
+
+// KEYS: live, watermark. ARGV: ttl in milliseconds, watermark or empty to keep it.
+// extend live hash and watermark together after a delta is applied, return 0 if the hash is gone
+var syncCommitScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+if ARGV[2] ~= '' then
+	redis.call('SET', KEYS[2], ARGV[2])
+end
+if tonumber(ARGV[1]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+	redis.call('PEXPIRE', KEYS[2], ARGV[1])
+end
+return 1
+`)
+
+// timeValue time.Time, *time.Time, sql.NullTime, gorm.DeletedAt -> time.Time
+func timeValue(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, !v.IsZero()
+	case *time.Time:
+		if v == nil {
+			return time.Time{}, false
+		}
+		return *v, !v.IsZero()
+	case sql.NullTime:
+		return v.Time, v.Valid
+	case gorm.DeletedAt:
+		return v.Time, v.Valid
+	}
+	return time.Time{}, false
+}
+
+// maxTime max value of time field in records
+func (s *FullTableCache) maxTime(sliceRef interface{}, fields ...string) (time.Time, bool) {
+	var r time.Time
+	found := false
+	vs := reflect.Indirect(reflect.ValueOf(sliceRef))
+	for i := 0; i < vs.Len(); i++ {
+		for _, f := range fields {
+			if f == "" {
+				continue
+			}
+			t, ok := timeValue(vs.Index(i).FieldByName(f).Interface())
+			if ok && (!found || t.After(r)) {
+				r = t
+				found = true
+			}
+		}
+	}
+	return r, found
+}
+
+// Sync apply rows changed since the watermark to the cache. fall back to full load if cache or watermark is missing
+func (s *FullTableCache) Sync() error {
+	if s.incremental == nil {
+		return s.Reload()
+	}
+	ok, err := s.Exist()
+	if err != nil {
+		return err
+	}
+	watermark, hasWatermark, err := s.getWatermark()
+	if err != nil {
+		return err
+	}
+	if !ok || !hasWatermark {
+		return s.Reload()
+	}
+	opts := s.incremental
+	since := watermark.Add(-opts.Overlap)
+	updatedAt := s.schema.LookUpField(opts.UpdatedAtField).DBName
+	records := s.FactoryListRef()
+	tx := s.db
+	if opts.DeletedAtField != "" {
+		deletedAt := s.schema.LookUpField(opts.DeletedAtField).DBName
+		tx = tx.Unscoped().Where(updatedAt+" >= ? OR "+deletedAt+" >= ?", since, since)
+	} else {
+		tx = tx.Where(updatedAt+" >= ?", since)
+	}
+	err = tx.Find(records).Error
+	if err != nil {
+		return err
+	}
+	var changed []interface{}
+	var deleted []string
+	vs := reflect.Indirect(reflect.ValueOf(records))
+	for i := 0; i < vs.Len(); i++ {
+		item := vs.Index(i)
+		if opts.DeletedAtField != "" {
+			if _, ok := timeValue(item.FieldByName(opts.DeletedAtField).Interface()); ok {
+				deleted = append(deleted, s.cacheUtil.Stringify(item.FieldByName(s.idField).Interface()))
+				continue
+			}
+		}
+		changed = append(changed, item.Addr().Interface())
+	}
+	var errs MultiError
+	if err := s.hset(changed); err != nil {
+		errs = append(errs, err)
+	}
+	if err := s.hdel(deleted...); err != nil {
+		errs = append(errs, err)
+	}
+	if n := atomic.AddInt64(&s.syncCount, 1); opts.DiffEvery > 0 && n%int64(opts.DiffEvery) == 0 {
+		if err := s.SyncDeletes(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	// only move the watermark forward after changes are applied. a synced hash is as fresh as a loaded one, keep it alive
+	watermarkStr := ""
+	if t, ok := s.maxTime(records, opts.UpdatedAtField, opts.DeletedAtField); ok && t.After(watermark) {
+		watermarkStr = formatWatermark(t)
+	}
+	keys := []string{s.key, s.getWatermarkKey()}
+	return syncCommitScript.Run(s.redisCtx, s.redisClient, keys, s.ttl.Milliseconds(), watermarkStr).Err()
+}
+
+// SyncDeletes remove cached rows whose ids no longer exist in db
+func (s *FullTableCache) SyncDeletes() error {
+	cached, err := s.redisClient.HKeys(s.redisCtx, s.key).Result()
+	if err != nil {
+		return err
+	}
+	idField := s.schema.LookUpField(s.idField)
+	ids := reflect.New(reflect.SliceOf(idField.FieldType)).Interface()
+	err = s.db.Model(s.FactorySingleRef()).Pluck(idField.DBName, ids).Error
+	if err != nil {
+		return err
+	}
+	return s.hdel(SubStrs(cached, append(ToStringSlice(ids), loadedField))...)
+}
+
+// StartSync call Sync every interval until ctx is done. errors are sent to onError if not nil
+func (s *FullTableCache) StartSync(ctx context.Context, interval time.Duration, onError func(error)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.Sync(); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
@@ -1,12 +1,14 @@
 package tablecache_test
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
 
 	"github.com/daqiancode/tablecache"
 	"github.com/stretchr/testify/suite"
+	"gorm.io/gorm/clause"
 )
 
 type FullTableCacheTest struct {
@@ -37,6 +39,106 @@ func (s *FullTableCacheTest) TestAll() {
 	s.full.Delete(12)
 }
 
+func (s *FullTableCacheTest) TestReload() {
+	s.Nil(s.full.Reload())
+	ok, err := s.full.Exist()
+	s.Nil(err)
+	fmt.Println(ok)
+	s.Nil(s.full.Load())
+}
+
+func (s *FullTableCacheTest) TestSync() {
+	s.full.EnableIncremental(tablecache.IncrementalOptions{UpdatedAtField: "UpdatedAt", DiffEvery: 1})
+	s.Nil(s.full.Sync())
+	s.Nil(s.full.Sync())
+	// incremental syncs keep the hash alive
+	ttl, err := GetRedis().PTTL(context.Background(), "test").Result()
+	s.Nil(err)
+	s.Greater(int64(ttl), int64(2*time.Second))
+}
+
+func (s *FullTableCacheTest) TestSnapshot() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Nil(s.full.EnableSnapshot(ctx, time.Second, nil))
+	s.full.OnChange(func(old, new interface{}) {
+		fmt.Println("changed:", old, new)
+	})
+	users, err := s.full.All()
+	s.Nil(err)
+	fmt.Println(users)
+	// own writes are visible without waiting for the change message
+	u := User{Name: "snapshot"}
+	s.Nil(s.full.Create(&u))
+	r, err := s.full.Get(u.ID)
+	s.Nil(err)
+	s.NotNil(r)
+	s.Nil(s.full.Delete(u.ID))
+	r, err = s.full.Get(u.ID)
+	s.Nil(err)
+	s.Nil(r)
+}
+
+func (s *FullTableCacheTest) TestListBy() {
+	s.full.AddIndex("Name")
+	users, err := s.full.ListBy("Name", "tom")
+	s.Nil(err)
+	fmt.Println(users)
+	u, err := s.full.GetBy("Name", "tom")
+	s.Nil(err)
+	fmt.Println(u)
+	users, err = s.full.Filter(func(r interface{}) bool { return r.(*User).ID > 10 })
+	s.Nil(err)
+	fmt.Println(users)
+}
+
+func (s *FullTableCacheTest) TestIterate() {
+	n := 0
+	err := s.full.Iterate(context.Background(), func(r interface{}) bool {
+		n++
+		fmt.Println(r.(*User))
+		return n < 10
+	})
+	s.Nil(err)
+}
+
+func (s *FullTableCacheTest) TestBulk() {
+	us := []User{{Name: "bulk1"}, {Name: "bulk2"}}
+	s.Nil(s.full.CreateMany(&us))
+	s.Nil(s.full.UpdateWhere(map[string]interface{}{"name": "bulk1"}, map[string]interface{}{"name": "bulk3"}))
+	us[1].Name = "bulk4"
+	s.Nil(s.full.Upsert(&us, clause.OnConflict{UpdateAll: true}))
+	s.Nil(s.full.Delete([]uint64{us[0].ID, us[1].ID}))
+}
+
+func (s *FullTableCacheTest) TestUpsertExisting() {
+	db := GetMysql()
+	s.Nil(db.AutoMigrate(&Account{}))
+	accounts := tablecache.NewFullTableCache(tablecache.NewRedisGorm(GetRedis(), db, 3*time.Second, "ID", "test",
+		func() interface{} { return &Account{} }, func() interface{} { return &([]Account{}) }), "Account")
+	a := Account{Email: "upsert@x", Age: 1}
+	s.Nil(accounts.Create(&a))
+	// mysql returns no id of the conflicting row, it is found by the unique email
+	up := []Account{{Email: "upsert@x", Age: 2}}
+	s.Nil(accounts.Upsert(&up, clause.OnConflict{UpdateAll: true}))
+	s.Equal(a.ID, up[0].ID)
+	r, err := accounts.Get(a.ID)
+	s.Nil(err)
+	s.Equal(2, r.(*Account).Age)
+	s.Nil(accounts.Delete(a.ID))
+}
+
+func (s *FullTableCacheTest) TestUpdatePersisted() {
+	u := User{Name: "before"}
+	s.Nil(s.full.Create(&u))
+	partial := User{Base: Base{ID: u.ID}, Name: "after"}
+	s.Nil(s.full.Update(&partial, "Name"))
+	s.Equal(u.CreatedAt.Unix(), partial.CreatedAt.Unix())
+	r, err := s.full.Get(u.ID)
+	s.Nil(err)
+	s.Equal("after", r.(*User).Name)
+}
+
 func TestFullTableCacheTest(t *testing.T) {
 	suite.Run(t, new(FullTableCacheTest))
 }
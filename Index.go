@@ -0,0 +1,81 @@
+package tablecache
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrIndexNotDeclared = errors.New("index not declared")
+	ErrIndexNotUnique   = errors.New("index not unique")
+)
+
+type IndexKind string
+
+const (
+	IndexUnique IndexKind = "unique"
+	IndexMulti  IndexKind = "multi"
+)
+
+// Index cache index of table. unique index -> id, multi index -> [id1,id2]
+type Index struct {
+	Kind   IndexKind
+	Fields []string
+}
+
+//Unique declare unique index, eg. Unique("Email")
+func Unique(fields ...string) Index {
+	return Index{Kind: IndexUnique, Fields: fields}
+}
+
+//Multi declare non-unique index, eg. Multi("ProjectID","Status")
+func Multi(fields ...string) Index {
+	return Index{Kind: IndexMulti, Fields: fields}
+}
+
+func (s Index) IsUnique() bool {
+	return s.Kind == IndexUnique
+}
+
+// Match fields with index fields, ignore case and order
+func (s Index) Match(fields []string) bool {
+	if len(fields) != len(s.Fields) {
+		return false
+	}
+	a := lowerSorted(s.Fields)
+	b := lowerSorted(fields)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s Index) String() string {
+	return string(s.Kind) + "(" + strings.Join(s.Fields, ",") + ")"
+}
+
+func lowerSorted(strs []string) []string {
+	r := make([]string, len(strs))
+	for i, v := range strs {
+		r[i] = strings.ToLower(v)
+	}
+	sort.Strings(r)
+	return r
+}
+
+func mapKeys(m map[string]interface{}) []string {
+	r := make([]string, 0, len(m))
+	for k := range m {
+		r = append(r, k)
+	}
+	sort.Strings(r)
+	return r
+}
+
+func indexNotDeclared(fields []string) error {
+	return fmt.Errorf("%w: %s", ErrIndexNotDeclared, strings.Join(fields, ","))
+}
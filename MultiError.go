@@ -0,0 +1,35 @@
+package tablecache
+
+import (
+	"errors"
+	"strings"
+)
+
+// MultiError errors aggregated from batch operations
+type MultiError []error
+
+func (s MultiError) Error() string {
+	strs := make([]string, len(s))
+	for i, v := range s {
+		strs[i] = v.Error()
+	}
+	return strings.Join(strs, "; ")
+}
+
+// ErrorOrNil return nil if no error
+func (s MultiError) ErrorOrNil() error {
+	if len(s) == 0 {
+		return nil
+	}
+	return s
+}
+
+// Is any error is target, for errors.Is
+func (s MultiError) Is(target error) bool {
+	for _, v := range s {
+		if errors.Is(v, target) {
+			return true
+		}
+	}
+	return false
+}
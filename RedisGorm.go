@@ -3,10 +3,13 @@ package tablecache
 import (
 	"context"
 	"reflect"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
 )
 
 type RedisGorm struct {
@@ -21,8 +24,12 @@ type RedisGorm struct {
 	cacheUtil        *CacheUtil
 	redisCtx         context.Context
 	idType           reflect.Kind
+	schema           *schema.Schema
+	batchSize        int
 }
 
+const DefaultBatchSize = 500
+
 func NewRedisGorm(redisClient *redis.Client, db *gorm.DB, ttl time.Duration, idField, cachePrefix string, factorySingleRef, factoryListRef func() interface{}) *RedisGorm {
 	r := &RedisGorm{
 		redisClient:      redisClient,
@@ -35,9 +42,11 @@ func NewRedisGorm(redisClient *redis.Client, db *gorm.DB, ttl time.Duration, idF
 		FactorySingleRef: factorySingleRef,
 		FactoryListRef:   factoryListRef,
 		redisCtx:         context.Background(),
+		batchSize:        DefaultBatchSize,
 	}
 	r.checkFields(idField)
 	r.setIDType()
+	r.parseSchema()
 	return r
 }
 
@@ -54,6 +63,52 @@ func (s *RedisGorm) setIDType() {
 	s.idType = f.FieldByName(s.idField).Kind()
 }
 
+func (s *RedisGorm) parseSchema() {
+	sch, err := schema.Parse(s.FactorySingleRef(), &sync.Map{}, s.db.NamingStrategy)
+	if err != nil {
+		panic(err)
+	}
+	s.schema = sch
+}
+
+func (s *RedisGorm) GetSchema() *schema.Schema {
+	return s.schema
+}
+
+// toColumns convert {Field:value} to {column:value} for gorm where conditions
+func (s *RedisGorm) toColumns(fieldValues map[string]interface{}) map[string]interface{} {
+	r := make(map[string]interface{}, len(fieldValues))
+	for k, v := range fieldValues {
+		if f := s.schema.LookUpField(k); f != nil && f.DBName != "" {
+			r[f.DBName] = v
+		} else {
+			r[k] = v
+		}
+	}
+	return r
+}
+
+// pickRow pick fields from a db row, which keys are column names
+func (s *RedisGorm) pickRow(row map[string]interface{}, fields ...string) map[string]interface{} {
+	r := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		column := field
+		if f := s.schema.LookUpField(field); f != nil && f.DBName != "" {
+			column = f.DBName
+		}
+		r[field] = row[column]
+	}
+	return r
+}
+
+// parseID parse id from it's string format
+func (s *RedisGorm) parseID(idStr string) (interface{}, error) {
+	if s.IsIDInteger() {
+		return strconv.ParseUint(idStr, 10, 64)
+	}
+	return idStr, nil
+}
+
 func (s *RedisGorm) IsIDInteger() bool {
 	return s.idType == reflect.Int || s.idType == reflect.Int8 || s.idType == reflect.Int16 || s.idType == reflect.Int32 || s.idType == reflect.Int64 ||
 		s.idType == reflect.Uint || s.idType == reflect.Uint8 || s.idType == reflect.Uint16 || s.idType == reflect.Uint32 || s.idType == reflect.Uint64
@@ -67,6 +122,51 @@ func (s *RedisGorm) SetRedisCtx(redisCtx context.Context) {
 	s.redisCtx = redisCtx
 }
 
+//SetBatchSize set max number of commands in one redis pipeline, and rows in one db batch
+func (s *RedisGorm) SetBatchSize(batchSize int) {
+	if batchSize > 0 {
+		s.batchSize = batchSize
+	}
+}
+
+func (s *RedisGorm) GetBatchSize() int {
+	return s.batchSize
+}
+
+// pipelined split n items into chunks of batchSize, send each chunk in one pipeline (one round trip). errors of all chunks are aggregated
+func (s *RedisGorm) pipelined(n int, f func(pipe redis.Pipeliner, i int) error) error {
+	var errs MultiError
+	for start := 0; start < n; start += s.batchSize {
+		end := start + s.batchSize
+		if end > n {
+			end = n
+		}
+		pipe := s.redisClient.Pipeline()
+		for i := start; i < end; i++ {
+			if err := f(pipe, i); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		cmds, err := pipe.Exec(s.redisCtx)
+		if err != nil {
+			for _, cmd := range cmds {
+				if cmd.Err() != nil && cmd.Err() != redis.Nil {
+					errs = append(errs, cmd.Err())
+				}
+			}
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// delKeys delete keys in chunked pipelines
+func (s *RedisGorm) delKeys(keys []string) error {
+	return s.pipelined(len(keys), func(pipe redis.Pipeliner, i int) error {
+		pipe.Del(s.redisCtx, keys[i])
+		return nil
+	})
+}
+
 func (s *RedisGorm) GetTTL() time.Duration {
 	return s.ttl
 }
@@ -1,10 +1,12 @@
 package tablecache
 
 import (
+	"database/sql/driver"
 	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
 
 	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
@@ -12,28 +14,60 @@ import (
 
 type TableCache struct {
 	*RedisGorm
-	structName string
-	Indexes    [][]string
+	structName  string
+	Indexes     []Index
+	blooms      *tableBlooms
+	writePolicy WritePolicy
+	registry    *CacheRegistry
 }
 
-func NewTableCache(redisGorm *RedisGorm, structName string, indexes [][]string) *TableCache {
+func NewTableCache(redisGorm *RedisGorm, structName string, indexes []Index) *TableCache {
 
 	r := &TableCache{
 		RedisGorm:  redisGorm,
 		structName: structName,
-		Indexes:    indexes,
 	}
-	if len(indexes) > 0 {
-		for _, v := range indexes {
-			r.checkFields(v...)
-		}
+	for _, v := range indexes {
+		r.AddIndex(v)
 	}
 	return r
 }
 
-func (s *TableCache) AddIndex(index []string) {
-	s.checkFields(index...)
+func (s *TableCache) AddIndex(index Index) {
+	s.checkFields(index.Fields...)
+	for _, v := range s.Indexes {
+		if v.Match(index.Fields) {
+			panic("index " + index.String() + " conflicts with " + v.String() + " in " + s.structName)
+		}
+	}
 	s.Indexes = append(s.Indexes, index)
+	s.addIndexBloom(index)
+}
+
+// findIndex find declared index by query fields
+func (s *TableCache) findIndex(index map[string]interface{}) (Index, error) {
+	fields := mapKeys(index)
+	for _, v := range s.Indexes {
+		if v.Match(fields) {
+			return v, nil
+		}
+	}
+	return Index{}, indexNotDeclared(fields)
+}
+
+// canonicalValues query values keyed by fields of index, so that cache keys and bloom values do not depend on
+// case of query fields. writes build keys from index fields too
+func canonicalValues(index Index, values map[string]interface{}) map[string]interface{} {
+	r := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		for _, f := range index.Fields {
+			if strings.EqualFold(k, f) {
+				r[f] = v
+				break
+			}
+		}
+	}
+	return r
 }
 
 func (s *TableCache) GetMaxID() (uint64, error) {
@@ -62,8 +96,9 @@ func (s *TableCache) getIDRedisKey(id interface{}) string {
 	return s.cachePrefix + "/" + s.structName + "/" + s.cacheUtil.MakeKey(s.idField, id)
 }
 
-func (s *TableCache) getIndexRedisKey(index map[string]interface{}) string {
-	return s.cachePrefix + "/" + s.structName + "/index/" + s.cacheUtil.MakeKeyWithMap(index)
+// unique and multi indexes have separate key spaces. eg. prefix/User/unique/email/a@b.c , prefix/User/multi/projectid/1
+func (s *TableCache) getIndexRedisKey(index Index, values map[string]interface{}) string {
+	return s.cachePrefix + "/" + s.structName + "/" + string(index.Kind) + "/" + s.cacheUtil.MakeKeyWithMap(values)
 }
 
 func (s *TableCache) cacheGetByID(id interface{}) (interface{}, bool, error) {
@@ -142,39 +177,18 @@ func (s *TableCache) cacheMGet(keys []string) ([]interface{}, error) {
 
 }
 
-func (s *TableCache) cacheGetInt(key string) (uint64, bool, error) {
+func (s *TableCache) cacheGetStr(key string) (string, bool, error) {
 	r, err := s.redisClient.Get(s.redisCtx, key).Result()
 	if err == redis.Nil {
-		return 0, false, nil
+		return "", false, nil
 	}
 	if err != nil {
-		return 0, false, err
+		return "", false, err
 	}
-	v, err := strconv.ParseUint(r, 10, 64)
-	return v, true, err
-}
-func (s *TableCache) cacheSetInt(key string, value uint64) error {
-	return s.redisClient.Set(s.redisCtx, key, strconv.FormatUint(value, 10), s.ttl).Err()
-}
-
-func (s *TableCache) cacheGetInts(key string) ([]uint64, bool, error) {
-	r, err := s.redisClient.Get(s.redisCtx, key).Result()
-	if err == redis.Nil {
-		return nil, false, nil
-	}
-	if err != nil {
-		return nil, false, err
-	}
-	var values []uint64
-	err = s.marshaller.Unmarshal(&values, r)
-	return values, true, err
+	return r, true, nil
 }
-func (s *TableCache) cacheSetInts(key string, values []uint64) error {
-	vlauesStr, err := s.marshaller.Marshal(values)
-	if err != nil {
-		return err
-	}
-	return s.redisClient.Set(s.redisCtx, key, vlauesStr, s.ttl).Err()
+func (s *TableCache) cacheSetStr(key string, value string) error {
+	return s.redisClient.Set(s.redisCtx, key, value, s.ttl).Err()
 }
 
 func (s *TableCache) pick(obj interface{}, keys []string) map[string]interface{} {
@@ -199,9 +213,15 @@ func (s *TableCache) Get(id interface{}) (interface{}, error) {
 			return nil, nil
 		}
 	}
-	r := s.FactorySingleRef()
+	exists, err := s.bloomMightContainIDs([]interface{}{id})
+	if err != nil {
+		return nil, err
+	}
+	if !exists[0] {
+		return nil, nil
+	}
 	key := s.getIDRedisKey(id)
-	r, ok, err := s.cacheGet(r, key)
+	r, ok, err := s.cacheGet(s.FactorySingleRef(), key)
 	if err != nil {
 		return nil, err
 	}
@@ -221,75 +241,98 @@ func (s *TableCache) Get(id interface{}) (interface{}, error) {
 	return r1, err
 }
 
-func (s *TableCache) hasNilInSlices(values []interface{}) bool {
-	for _, v := range values {
-		if v == nil {
-			return true
+//List records by ids. result is in order of ids, not found records are skipped
+func (s *TableCache) List(ids interface{}) (interface{}, error) {
+	r := s.FactoryListRef()
+	records, err := s.ListPositional(ids)
+	if err != nil {
+		return r, err
+	}
+	rV := reflect.ValueOf(r).Elem()
+	for _, v := range records {
+		if v != nil {
+			rV.Set(reflect.Append(rV, reflect.ValueOf(v).Elem()))
 		}
 	}
-	return false
+	return r, nil
 }
-func (s *TableCache) List(ids interface{}) (interface{}, error) {
+
+//ListPositional list records by ids. result[i] is record of ids[i], nil if not found
+func (s *TableCache) ListPositional(ids interface{}) ([]interface{}, error) {
 	if ids == nil {
-		return s.FactoryListRef(), nil
+		return nil, nil
+	}
+	idList := toInterfaces(ids)
+	n := len(idList)
+	exists, err := s.bloomMightContainIDs(idList)
+	if err != nil {
+		return nil, err
 	}
-	idsV := reflect.Indirect(reflect.ValueOf(ids))
-	n := idsV.Len()
 	keys := make([]string, n)
-	for i := 0; i < n; i++ {
-		keys[i] = s.getIDRedisKey(idsV.Index(i).Interface())
+	for i, id := range idList {
+		keys[i] = s.getIDRedisKey(id)
 	}
 	strs, err := s.cacheMGet(keys)
 	if err != nil {
 		return nil, err
 	}
-
-	// no nil value in redis,
-	if !s.hasNilInSlices(strs) {
-		r := s.FactoryListRef()
-		jsonStr := "[ "
-		for _, v := range strs {
-			if v == nil || NullStr == v {
-				// jsonStr += "null,"
-				continue
+	r := make([]interface{}, n)
+	var missIDs []interface{}
+	missed := make(map[string]bool)
+	for i, v := range strs {
+		if !exists[i] {
+			strs[i] = NullStr
+			continue
+		}
+		if v == nil {
+			k := s.cacheUtil.Stringify(idList[i])
+			if !missed[k] {
+				missed[k] = true
+				missIDs = append(missIDs, idList[i])
 			}
-			jsonStr += v.(string) + ","
+			continue
 		}
-		jsonStr = jsonStr[0:len(jsonStr)-1] + "]"
-		err = s.marshaller.Unmarshal(r, jsonStr)
-		return r, err
+		if v.(string) == NullStr {
+			continue
+		}
+		record := s.FactorySingleRef()
+		err = s.marshaller.Unmarshal(record, v.(string))
+		if err != nil {
+			return nil, err
+		}
+		r[i] = record
 	}
-	// fetch from db and store into redis
-	r1 := s.FactoryListRef()
-	tx := s.db.Where(ids).Find(r1)
-	if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
-		return r1, tx.Error
+	if len(missIDs) == 0 {
+		return r, nil
 	}
-	var key string
-
-	// var id uint64
-	var goodIds []interface{}
-	rV := reflect.Indirect(reflect.ValueOf(r1))
-	m := rV.Len()
-	for i := 0; i < m; i++ {
-		ele := rV.Index(i)
-		id := ele.FieldByName(s.idField).Interface()
-		err = s.cacheSet(ele.Interface(), s.getIDRedisKey(id))
-		if err != nil {
-			return r1, err
+	// fetch missing ids from db and store into redis
+	missList, err := s.fetchIn(s.idField, missIDs)
+	if err != nil {
+		return nil, err
+	}
+	found := s.mapByID(missList)
+	err = s.pipelined(len(missIDs), func(pipe redis.Pipeliner, i int) error {
+		jsonStr := NullStr
+		if record, ok := found[s.cacheUtil.Stringify(missIDs[i])]; ok {
+			jsonStr, err = s.marshaller.Marshal(record)
+			if err != nil {
+				return err
+			}
 		}
-		goodIds = append(goodIds, id)
+		pipe.Set(s.redisCtx, s.getIDRedisKey(missIDs[i]), jsonStr, s.ttl)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	badIds := SubStrs(ToStringSlice(ids), ToStringSlice(goodIds))
-	for _, v := range badIds {
-		key = s.getIDRedisKey(v)
-		s.cacheSet(nil, key)
-		// err := s.redisClient.Set(s.redisCtx, key, NullStr, s.ttl).Err()
-		if err != nil {
-			return r1, err
+	for i, v := range strs {
+		if v == nil {
+			if record, ok := found[s.cacheUtil.Stringify(idList[i])]; ok {
+				r[i] = record
+			}
 		}
 	}
-	return r1, nil
+	return r, nil
 }
 
 //GetBy index ,index:eg. uid,1
@@ -297,31 +340,50 @@ func (s *TableCache) GetBy(index ...interface{}) (interface{}, error) {
 	return s.GetByMap(argsToMap(index...))
 }
 
-// redis key eg. projectusers/pid/2/uid/1 -> id
+// redis key eg. projectusers/unique/pid/2/uid/1 -> id. index must be declared as unique
 func (s *TableCache) GetByMap(index map[string]interface{}) (interface{}, error) {
-	key := s.getIndexRedisKey(index)
-	id, ok, err := s.cacheGetInt(key)
+	idx, err := s.findIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	if !idx.IsUnique() {
+		return nil, fmt.Errorf("%w: %s, use ListBy instead", ErrIndexNotUnique, idx)
+	}
+	index = canonicalValues(idx, index)
+	exists, err := s.bloomMightContainIndex(idx, []map[string]interface{}{index})
+	if err != nil {
+		return nil, err
+	}
+	if !exists[0] {
+		return nil, nil
+	}
+	key := s.getIndexRedisKey(idx, index)
+	idStr, ok, err := s.cacheGetStr(key)
 	if err != nil {
 		return nil, err
 	}
 	if ok { //hit
-		if id == 0 {
+		if idStr == NullStr {
 			return nil, nil
 		}
+		id, err := s.parseID(idStr)
+		if err != nil {
+			return nil, err
+		}
 		return s.Get(id)
 	}
 
 	// miss
 	r1 := s.FactorySingleRef()
-	err = s.db.Where(index).Take(r1).Error
+	err = s.db.Where(s.toColumns(index)).Take(r1).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
-		err = s.cacheSetInt(key, 0)
+		err = s.cacheSetStr(key, NullStr)
 		return nil, err
 	}
 	if err != nil {
 		return nil, err
 	}
-	err = s.cacheSetInt(key, s.GetID(r1).(uint64))
+	err = s.cacheSetStr(key, s.cacheUtil.Stringify(s.GetID(r1)))
 	return r1, err
 }
 
@@ -330,34 +392,363 @@ func (s *TableCache) ListBy(index ...interface{}) (interface{}, error) {
 	return s.ListByMap(argsToMap(index...))
 }
 
-// redis key eg. projectusers/pid/2/uid/1 -> [id1,id2]
+// redis key eg. projectusers/multi/pid/2 -> set of ids. unique index returns list with at most one record
 func (s *TableCache) ListByMap(index map[string]interface{}) (interface{}, error) {
-	key := s.getIndexRedisKey(index)
-	var ids []uint64
-	ids, ok, err := s.cacheGetInts(key)
-	fmt.Println(key, ids, ok, err)
+	idx, err := s.findIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	if idx.IsUnique() {
+		r, err := s.GetByMap(index)
+		if err != nil || r == nil {
+			return s.FactoryListRef(), err
+		}
+		return s.wrapList(r), nil
+	}
+	index = canonicalValues(idx, index)
+	key := s.getIndexRedisKey(idx, index)
+	idStrs, ok, err := s.getSetMembers(key)
 	if err != nil {
-		return s.FactorySingleRef, err
+		return nil, err
 	}
 	if ok { //hit
+		ids, err := s.parseIDStrs(idStrs)
+		if err != nil {
+			return nil, err
+		}
 		return s.List(ids)
 	}
 
 	// miss
 	r1 := s.FactoryListRef()
-	err = s.db.Where(index).Find(r1).Error
+	err = s.db.Where(s.toColumns(index)).Find(r1).Error
 	if err != nil {
 		return nil, err
 	}
+	_, err = s.redisClient.TxPipelined(s.redisCtx, func(pipe redis.Pipeliner) error {
+		s.pipeSetMembers(pipe, key, itemRefs(r1))
+		return nil
+	})
+	return r1, err
+}
 
-	r1V := reflect.Indirect(reflect.ValueOf(r1))
-	n := r1V.Len()
-	newIds := make([]uint64, n)
+// wrapList single record -> list, eg. *User -> *[]User
+func (s *TableCache) wrapList(valueRef interface{}) interface{} {
+	r := s.FactoryListRef()
+	rV := reflect.ValueOf(r).Elem()
+	rV.Set(reflect.Append(rV, reflect.Indirect(reflect.ValueOf(valueRef))))
+	return r
+}
+
+// mapByID list -> {id: *record}
+func (s *TableCache) mapByID(listRef interface{}) map[string]interface{} {
+	rV := reflect.Indirect(reflect.ValueOf(listRef))
+	n := rV.Len()
+	r := make(map[string]interface{}, n)
 	for i := 0; i < n; i++ {
-		newIds[i] = r1V.Index(i).FieldByName(s.idField).Uint()
+		ele := rV.Index(i)
+		r[s.cacheUtil.Stringify(ele.FieldByName(s.idField).Interface())] = ele.Addr().Interface()
 	}
-	err = s.cacheSetInts(key, newIds)
-	return r1, err
+	return r
+}
+
+// fetchIn query records by field in values from db
+func (s *TableCache) fetchIn(field string, values []interface{}) (interface{}, error) {
+	r := s.FactoryListRef()
+	if len(values) == 0 {
+		return r, nil
+	}
+	column := s.toColumns(map[string]interface{}{field: nil})
+	for k := range column {
+		field = k
+	}
+	err := s.db.Where(field+" IN ?", values).Find(r).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return r, err
+	}
+	return r, nil
+}
+
+//GetByMany batch version of GetBy on single field unique index. result is in order of values, nil if not found. eg. GetByMany("Email", []string{"a@b.c"})
+func (s *TableCache) GetByMany(field string, values interface{}) ([]interface{}, error) {
+	vs := toInterfaces(values)
+	idx, err := s.findIndex(map[string]interface{}{field: nil})
+	if err != nil {
+		return nil, err
+	}
+	if !idx.IsUnique() {
+		return nil, fmt.Errorf("%w: %s, use ListByMany instead", ErrIndexNotUnique, idx)
+	}
+	n := len(vs)
+	r := make([]interface{}, n)
+	if n == 0 {
+		return r, nil
+	}
+	keys := make([]string, n)
+	indexValues := make([]map[string]interface{}, n)
+	for i, v := range vs {
+		indexValues[i] = map[string]interface{}{field: v}
+		keys[i] = s.getIndexRedisKey(idx, indexValues[i])
+	}
+	exists, err := s.bloomMightContainIndex(idx, indexValues)
+	if err != nil {
+		return nil, err
+	}
+	idStrs, err := s.cacheMGet(keys)
+	if err != nil {
+		return nil, err
+	}
+	var hitIDs []interface{}
+	var missValues []interface{}
+	var missIdx []int
+	for i, v := range idStrs {
+		if !exists[i] {
+			idStrs[i] = NullStr
+			continue
+		}
+		if v == nil {
+			missValues = append(missValues, vs[i])
+			missIdx = append(missIdx, i)
+			continue
+		}
+		if v.(string) == NullStr {
+			continue
+		}
+		id, err := s.parseID(v.(string))
+		if err != nil {
+			return nil, err
+		}
+		hitIDs = append(hitIDs, id)
+	}
+	hits := map[string]interface{}{}
+	if len(hitIDs) > 0 {
+		list, err := s.List(hitIDs)
+		if err != nil {
+			return nil, err
+		}
+		hits = s.mapByID(list)
+	}
+	// miss: one query for all missing values, then fill index keys and record keys in one pipeline
+	missList, err := s.fetchIn(field, missValues)
+	if err != nil {
+		return nil, err
+	}
+	groups, ambiguous := s.matchRows(missList, field, missValues)
+	err = s.pipelined(len(missValues), func(pipe redis.Pipeliner, i int) error {
+		if ambiguous[i] {
+			return nil
+		}
+		key := s.getIndexRedisKey(idx, map[string]interface{}{field: missValues[i]})
+		if len(groups[i]) == 0 {
+			pipe.Set(s.redisCtx, key, NullStr, s.ttl)
+			return nil
+		}
+		record := groups[i][0]
+		id := s.GetID(record)
+		jsonStr, err := s.marshaller.Marshal(record)
+		if err != nil {
+			return err
+		}
+		pipe.Set(s.redisCtx, key, s.cacheUtil.Stringify(id), s.ttl)
+		pipe.Set(s.redisCtx, s.getIDRedisKey(id), jsonStr, s.ttl)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range idStrs {
+		if v == nil {
+			continue
+		}
+		if record, ok := hits[v.(string)]; ok {
+			r[i] = record
+		}
+	}
+	for j, i := range missIdx {
+		switch {
+		case ambiguous[j]:
+			// db decides alone
+			if r[i], err = s.GetByMap(map[string]interface{}{field: vs[i]}); err != nil {
+				return nil, err
+			}
+		case len(groups[j]) > 0:
+			r[i] = groups[j][0]
+		}
+	}
+	return r, nil
+}
+
+//ListByMany batch version of ListBy on single field index. result is in order of values, each item is list ref eg. *[]User
+func (s *TableCache) ListByMany(field string, values interface{}) ([]interface{}, error) {
+	vs := toInterfaces(values)
+	idx, err := s.findIndex(map[string]interface{}{field: nil})
+	if err != nil {
+		return nil, err
+	}
+	n := len(vs)
+	r := make([]interface{}, n)
+	if idx.IsUnique() {
+		records, err := s.GetByMany(field, vs)
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range records {
+			if v == nil {
+				r[i] = s.FactoryListRef()
+			} else {
+				r[i] = s.wrapList(v)
+			}
+		}
+		return r, nil
+	}
+	if n == 0 {
+		return r, nil
+	}
+	keys := make([]string, n)
+	for i, v := range vs {
+		keys[i] = s.getIndexRedisKey(idx, map[string]interface{}{field: v})
+	}
+	members := make([]*redis.StringSliceCmd, n)
+	_, err = s.redisClient.Pipelined(s.redisCtx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			members[i] = pipe.SMembers(s.redisCtx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	hitIDs := make([][]string, n)
+	var allHitIDs []interface{}
+	var missValues []interface{}
+	var missIdx []int
+	for i, cmd := range members {
+		if len(cmd.Val()) == 0 {
+			missValues = append(missValues, vs[i])
+			missIdx = append(missIdx, i)
+			continue
+		}
+		hitIDs[i] = s.parseSetMembers(cmd.Val())
+		ids, err := s.parseIDStrs(hitIDs[i])
+		if err != nil {
+			return nil, err
+		}
+		allHitIDs = append(allHitIDs, ids...)
+	}
+	hits := map[string]interface{}{}
+	if len(allHitIDs) > 0 {
+		list, err := s.List(allHitIDs)
+		if err != nil {
+			return nil, err
+		}
+		hits = s.mapByID(list)
+	}
+	missList, err := s.fetchIn(field, missValues)
+	if err != nil {
+		return nil, err
+	}
+	groups, ambiguous := s.matchRows(missList, field, missValues)
+	err = s.pipelined(len(missValues), func(pipe redis.Pipeliner, i int) error {
+		if ambiguous[i] {
+			return nil
+		}
+		records := groups[i]
+		for _, record := range records {
+			jsonStr, err := s.marshaller.Marshal(record)
+			if err != nil {
+				return err
+			}
+			pipe.Set(s.redisCtx, s.getIDRedisKey(s.GetID(record)), jsonStr, s.ttl)
+		}
+		s.pipeSetMembers(pipe, s.getIndexRedisKey(idx, map[string]interface{}{field: missValues[i]}), records)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	missGroups := make(map[int][]interface{}, len(missIdx))
+	for j, i := range missIdx {
+		if ambiguous[j] {
+			// db decides alone
+			if r[i], err = s.ListByMap(map[string]interface{}{field: vs[i]}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		missGroups[i] = groups[j]
+	}
+	for i, cmd := range members {
+		if r[i] != nil {
+			continue
+		}
+		list := s.FactoryListRef()
+		listV := reflect.ValueOf(list).Elem()
+		var records []interface{}
+		if len(cmd.Val()) == 0 {
+			records = missGroups[i]
+		} else {
+			for _, id := range hitIDs[i] {
+				if record, ok := hits[id]; ok {
+					records = append(records, record)
+				}
+			}
+		}
+		for _, record := range records {
+			listV.Set(reflect.Append(listV, reflect.ValueOf(record).Elem()))
+		}
+		r[i] = list
+	}
+	return r, nil
+}
+
+// matchKey value as compared with query inputs, pointers are dereferenced and valuers resolved
+func (s *TableCache) matchKey(v interface{}) string {
+	if rv := reflect.ValueOf(v); !rv.IsValid() || rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return NullStr
+	}
+	if valuer, ok := v.(driver.Valuer); ok {
+		if dv, err := valuer.Value(); err == nil {
+			v = dv
+		}
+	}
+	return s.cacheUtil.StringifyDeref(v)
+}
+
+// matchRows group rows fetched by field IN values back to values. a value is ambiguous if db may have matched rows
+// which do not equal it exactly, eg. under case insensitive collation. ambiguous values must be queried alone, and
+// no null marker is cached for them
+func (s *TableCache) matchRows(listRef interface{}, field string, values []interface{}) ([][]interface{}, []bool) {
+	exact := make(map[string][]interface{})
+	folded := make(map[string]int)
+	rV := reflect.Indirect(reflect.ValueOf(listRef))
+	for i := 0; i < rV.Len(); i++ {
+		ele := rV.Index(i).Addr().Interface()
+		k := s.matchKey(s.cacheUtil.GetFieldValue(ele, field))
+		exact[k] = append(exact[k], ele)
+		folded[strings.ToLower(k)]++
+	}
+	groups := make([][]interface{}, len(values))
+	ambiguous := make([]bool, len(values))
+	claimed := 0
+	seen := make(map[string]bool, len(values))
+	for i, v := range values {
+		k := s.matchKey(v)
+		groups[i] = exact[k]
+		ambiguous[i] = folded[strings.ToLower(k)] != len(exact[k])
+		if !seen[k] {
+			seen[k] = true
+			claimed += len(exact[k])
+		}
+	}
+	if claimed < rV.Len() {
+		// some rows equal no value, values without rows may own them
+		for i := range values {
+			if len(groups[i]) == 0 {
+				ambiguous[i] = true
+			}
+		}
+	}
+	return groups, ambiguous
 }
 
 func (s *TableCache) Create(valueRef interface{}) error {
@@ -365,12 +756,12 @@ func (s *TableCache) Create(valueRef interface{}) error {
 	if tx.Error != nil {
 		return tx.Error
 	}
-	return s.ClearCache(valueRef)
+	return s.afterWrite([]interface{}{valueRef}, nil)
 
 }
 
 func (s *TableCache) CreateMany(sliceRef interface{}) error {
-	err := s.db.CreateInBatches(sliceRef, 200).Error
+	err := s.db.CreateInBatches(sliceRef, s.batchSize).Error
 	if err != nil {
 		return err
 	}
@@ -378,17 +769,36 @@ func (s *TableCache) CreateMany(sliceRef interface{}) error {
 	n := sr.Len()
 	var objs []interface{}
 	for i := 0; i < n; i++ {
-		objs = append(objs, sr.Index(i).Interface())
+		objs = append(objs, sr.Index(i).Addr().Interface())
 	}
-	return s.ClearCache(objs...)
+	return s.afterWrite(objs, nil)
 }
 
 func (s *TableCache) Save(valueRef interface{}) error {
+	var olds []interface{}
+	// old row is needed to clear index keys of changed values
+	if !reflect.ValueOf(s.GetID(valueRef)).IsZero() {
+		old, err := s.takeFromDB(s.GetID(valueRef))
+		if err != nil {
+			return err
+		}
+		if old != nil {
+			olds = append(olds, old)
+		}
+	}
+	effects, err := s.cascadeBefore(olds, false)
+	if err != nil {
+		return err
+	}
 	tx := s.db.Save(valueRef)
 	if tx.Error != nil {
 		return tx.Error
 	}
-	return s.ClearCache(valueRef)
+	err = s.afterWrite([]interface{}{valueRef}, olds)
+	if err != nil {
+		return err
+	}
+	return s.cascadeAfter(effects, olds, []interface{}{valueRef})
 }
 
 //Delete by id , eg. Delete(1,2)
@@ -397,9 +807,14 @@ func (s *TableCache) Delete(ids ...interface{}) error {
 	if len(ids) == 1 && isSlice(ids[0]) {
 		args = ids[0]
 	}
-	var old []map[string]interface{}
+	old := s.FactoryListRef()
 	m := s.FactorySingleRef()
-	err := s.db.Model(m).Where(args).Find(&old).Error
+	err := s.db.Model(m).Where(args).Find(old).Error
+	if err != nil {
+		return err
+	}
+	olds := itemRefs(old)
+	effects, err := s.cascadeBefore(olds, true)
 	if err != nil {
 		return err
 	}
@@ -408,33 +823,66 @@ func (s *TableCache) Delete(ids ...interface{}) error {
 	if err != nil {
 		return err
 	}
-	return s.ClearCacheWithMaps(old...)
+	err = s.invalidate(nil, olds)
+	if err != nil {
+		return err
+	}
+	return s.cascadeAfter(effects, olds, nil)
 }
 func (s *TableCache) Update(resultRef interface{}, fields ...string) error {
-	v1 := make(map[string]interface{})
-	m := s.FactorySingleRef()
-	err := s.db.Model(m).Take(&v1, s.GetID(resultRef)).Error
+	id := s.GetID(resultRef)
+	old, err := s.takeFromDB(id)
+	if err != nil {
+		return err
+	}
+	if old == nil {
+		return gorm.ErrRecordNotFound
+	}
+	olds := []interface{}{old}
+	effects, err := s.cascadeBefore(olds, false)
 	if err != nil {
 		return err
 	}
-	m1 := s.FactorySingleRef()
 	var tx *gorm.DB
 	if len(fields) > 0 {
-		tx = s.db.Model(m1).Select(fields).Updates(resultRef)
+		tx = s.db.Model(resultRef).Select(fields).Updates(resultRef)
 	} else {
-		tx = s.db.Model(m1).Select("*").Updates(resultRef)
+		tx = s.db.Model(resultRef).Select("*").Updates(resultRef)
 	}
 	if tx.Error != nil {
 		return tx.Error
 	}
-	v2 := make(map[string]interface{})
-	err = s.db.Model(m1).Take(&v2, s.GetID(resultRef)).Error
+	fresh, err := s.takeFromDB(id)
+	if err != nil {
+		return err
+	}
+	var news []interface{}
+	if fresh != nil {
+		news = append(news, fresh)
+	}
+	err = s.updateCache(news, olds)
+	if err != nil {
+		return err
+	}
+	return s.cascadeAfter(effects, olds, news)
+}
+
+// updateCache after Update, news are re-read from db
+func (s *TableCache) updateCache(news []interface{}, olds []interface{}) error {
+	if len(news) == 0 {
+		return s.invalidate(nil, olds)
+	}
+	err := s.bloomAdd(news...)
 	if err != nil {
 		return err
 	}
-	return s.ClearCacheWithMaps(v1, v2)
+	if s.writePolicy == WriteInvalidate {
+		return s.invalidate(news, olds)
+	}
+	return s.writeThrough(news, olds)
 }
 
+//ClearCache delete id keys and index keys of objs, whole index sets included
 func (s *TableCache) ClearCache(objs ...interface{}) error {
 	if len(objs) == 0 {
 		return nil
@@ -443,27 +891,26 @@ func (s *TableCache) ClearCache(objs ...interface{}) error {
 	if len(objs) == 1 && isSlice(objs[0]) {
 		args = objs[0]
 	}
-	objsV := reflect.Indirect(reflect.ValueOf(args))
-	n := objsV.Len()
-	m := n*(len(s.Indexes)+1) + 1
-	var keySet map[string]bool = make(map[string]bool, m)
+	return s.delKeys(s.cacheKeysOf(itemRefs(args), true))
+}
+
+// cacheKeysOf max key, id keys and index keys of objs. multi index sets only if withMulti
+func (s *TableCache) cacheKeysOf(objs []interface{}, withMulti bool) []string {
+	keySet := make(map[string]bool, len(objs)*(len(s.Indexes)+1)+1)
 	keySet[s.getMaxRedisKey()] = true
-	for i := 0; i < n; i++ {
-		v := reflect.Indirect(objsV.Index(i)).Interface()
-		id := s.GetID(v).(uint64)
-		keySet[s.getIDRedisKey(id)] = true
-		for _, pairs := range s.Indexes {
-			d := s.pick(v, pairs)
-			keySet[s.getIndexRedisKey(d)] = true
+	for _, v := range objs {
+		keySet[s.getIDRedisKey(s.GetID(v))] = true
+		for _, idx := range s.Indexes {
+			if withMulti || idx.IsUnique() {
+				keySet[s.getIndexRedisKey(idx, s.pick(v, idx.Fields))] = true
+			}
 		}
 	}
-	rkeys := make([]string, m)
-	i := 0
+	rkeys := make([]string, 0, len(keySet))
 	for k := range keySet {
-		rkeys[i] = k
-		i++
+		rkeys = append(rkeys, k)
 	}
-	return s.redisClient.Del(s.redisCtx, rkeys...).Err()
+	return rkeys
 }
 
 func (s *TableCache) ClearCacheWithMaps(objs ...map[string]interface{}) error {
@@ -476,20 +923,16 @@ func (s *TableCache) ClearCacheWithMaps(objs ...map[string]interface{}) error {
 	keySet[s.getMaxRedisKey()] = true
 	for i := 0; i < n; i++ {
 		v := objs[i]
-		keySet[s.cacheUtil.MakeKeyWithMap(pickFromMap(v, s.idField))] = true
-		for _, pairs := range s.Indexes {
-			m := pickFromMap(v, pairs...)
-			keySet[s.getIndexRedisKey(m)] = true
+		keySet[s.getIDRedisKey(s.pickRow(v, s.idField)[s.idField])] = true
+		for _, idx := range s.Indexes {
+			keySet[s.getIndexRedisKey(idx, s.pickRow(v, idx.Fields...))] = true
 		}
 	}
-
-	rkeys := make([]string, m)
-	i := 0
+	rkeys := make([]string, 0, len(keySet))
 	for k := range keySet {
-		rkeys[i] = k
-		i++
+		rkeys = append(rkeys, k)
 	}
-	return s.redisClient.Del(s.redisCtx, rkeys...).Err()
+	return s.delKeys(rkeys)
 }
 
 func (s *TableCache) DeleteUint64s(ids []uint64) error {
@@ -0,0 +1,184 @@
+package tablecache
+
+import (
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// bloom filters against cache penetration, for ids and unique indexes
+type tableBlooms struct {
+	expected          uint64
+	falsePositiveRate float64
+	id                *BloomFilter
+	indexes           map[string]*BloomFilter // index.String(): filter
+}
+
+// EnableBloom guard Get and unique index lookups with bloom filters. eg. EnableBloom(1000000, 0.001)
+// Filters are consulted only after WarmBloom built them.
+func (s *TableCache) EnableBloom(expected uint64, falsePositiveRate float64) {
+	s.blooms = &tableBlooms{
+		expected:          expected,
+		falsePositiveRate: falsePositiveRate,
+		indexes:           make(map[string]*BloomFilter),
+	}
+	s.blooms.id = NewBloomFilter(s.redisClient, s.getBloomRedisKey(s.idField), expected, falsePositiveRate)
+	for _, idx := range s.Indexes {
+		s.addIndexBloom(idx)
+	}
+}
+
+func (s *TableCache) addIndexBloom(idx Index) {
+	if s.blooms == nil || !idx.IsUnique() {
+		return
+	}
+	key := s.getBloomRedisKey(lowerSorted(idx.Fields)...)
+	s.blooms.indexes[idx.String()] = NewBloomFilter(s.redisClient, key, s.blooms.expected, s.blooms.falsePositiveRate)
+}
+
+// eg. prefix/User/bloom/email
+func (s *TableCache) getBloomRedisKey(fields ...string) string {
+	return s.cachePrefix + "/" + s.structName + "/bloom/" + strings.ToLower(strings.Join(fields, "/"))
+}
+
+// bloomMightContainIDs all true if bloom is disabled
+func (s *TableCache) bloomMightContainIDs(ids []interface{}) ([]bool, error) {
+	if s.blooms == nil {
+		r := make([]bool, len(ids))
+		for i := range r {
+			r[i] = true
+		}
+		return r, nil
+	}
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = s.cacheUtil.Stringify(id)
+	}
+	return s.blooms.id.MightContainMany(s.redisCtx, values)
+}
+
+// bloomMightContainIndex check unique index values, all true if no filter for the index
+func (s *TableCache) bloomMightContainIndex(idx Index, indexValues []map[string]interface{}) ([]bool, error) {
+	var filter *BloomFilter
+	if s.blooms != nil {
+		filter = s.blooms.indexes[idx.String()]
+	}
+	if filter == nil {
+		r := make([]bool, len(indexValues))
+		for i := range r {
+			r[i] = true
+		}
+		return r, nil
+	}
+	values := make([]string, len(indexValues))
+	for i, v := range indexValues {
+		values[i] = s.cacheUtil.MakeKeyWithMap(v)
+	}
+	return filter.MightContainMany(s.redisCtx, values)
+}
+
+// bloomAdd add ids and unique index values of records into filters
+func (s *TableCache) bloomAdd(objs ...interface{}) error {
+	if s.blooms == nil || len(objs) == 0 {
+		return nil
+	}
+	var args interface{} = objs
+	if len(objs) == 1 && isSlice(objs[0]) {
+		args = objs[0]
+	}
+	objsV := reflect.Indirect(reflect.ValueOf(args))
+	n := objsV.Len()
+	rows := make([]map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		v := reflect.Indirect(objsV.Index(i)).Interface()
+		rows[i] = s.pick(v, s.bloomFields())
+	}
+	return s.bloomAddFields(rows)
+}
+
+// bloomFields id field and fields of unique indexes
+func (s *TableCache) bloomFields() []string {
+	r := []string{s.idField}
+	for _, idx := range s.Indexes {
+		if idx.IsUnique() {
+			r = append(r, idx.Fields...)
+		}
+	}
+	return r
+}
+
+// bloomAddFields records are {Field: value}
+func (s *TableCache) bloomAddFields(records []map[string]interface{}) error {
+	return s.bloomAddTo(s.blooms.id, s.blooms.indexes, records)
+}
+
+func (s *TableCache) bloomAddTo(idFilter *BloomFilter, indexFilters map[string]*BloomFilter, records []map[string]interface{}) error {
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i] = s.cacheUtil.Stringify(record[s.idField])
+	}
+	var errs MultiError
+	if err := idFilter.Add(s.redisCtx, ids...); err != nil {
+		errs = append(errs, err)
+	}
+	for _, idx := range s.Indexes {
+		filter := indexFilters[idx.String()]
+		if filter == nil {
+			continue
+		}
+		values := make([]string, len(records))
+		for i, record := range records {
+			values[i] = s.cacheUtil.MakeKeyWithMap(pickFromMap(record, idx.Fields...))
+		}
+		if err := filter.Add(s.redisCtx, values...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// WarmBloom scan the table in batches to (re)build bloom filters. filters are replaced atomically after scan
+func (s *TableCache) WarmBloom() error {
+	if s.blooms == nil {
+		return nil
+	}
+	idStaging, err := s.blooms.id.NewStaging(s.redisCtx)
+	if err != nil {
+		return err
+	}
+	indexStagings := make(map[string]*BloomFilter, len(s.blooms.indexes))
+	for k, filter := range s.blooms.indexes {
+		indexStagings[k], err = filter.NewStaging(s.redisCtx)
+		if err != nil {
+			return err
+		}
+	}
+	fields := s.bloomFields()
+	columns := make([]string, len(fields))
+	for i, f := range fields {
+		columns[i] = s.schema.LookUpField(f).DBName
+	}
+	records := s.FactoryListRef()
+	err = s.db.Model(s.FactorySingleRef()).Select(columns).FindInBatches(records, s.batchSize, func(tx *gorm.DB, batch int) error {
+		rV := reflect.Indirect(reflect.ValueOf(records))
+		rows := make([]map[string]interface{}, rV.Len())
+		for i := range rows {
+			rows[i] = s.pick(rV.Index(i).Interface(), fields)
+		}
+		return s.bloomAddTo(idStaging, indexStagings, rows)
+	}).Error
+	if err != nil {
+		return err
+	}
+	var errs MultiError
+	if err := s.blooms.id.Publish(s.redisCtx, idStaging); err != nil {
+		errs = append(errs, err)
+	}
+	for k, filter := range s.blooms.indexes {
+		if err := filter.Publish(s.redisCtx, indexStagings[k]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.ErrorOrNil()
+}
@@ -0,0 +1,319 @@
+package tablecache
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm/schema"
+)
+
+var (
+	ErrRelationNotFound    = errors.New("relation not found")
+	ErrRelationUnsupported = errors.New("relation not supported")
+	ErrCacheNotRegistered  = errors.New("table cache not registered")
+)
+
+//Preload load associations of records through TableCaches of related tables, in batches.
+// records is *Table, *[]Table or []*Table. associations are gorm relations, nested by dot. eg.
+//	projects.Preload(&list, "Members.User", "Owner")
+// related tables must be registered in the same CacheRegistry. has-one and has-many need an index on the foreign key,
+// many2many join rows are read by the join table's TableCache if registered, else by one db query
+func (s *TableCache) Preload(records interface{}, associations ...string) error {
+	refs := recordRefs(records)
+	if len(refs) == 0 {
+		return nil
+	}
+	nested := make(map[string][]string)
+	var names []string
+	for _, a := range associations {
+		parts := strings.SplitN(a, ".", 2)
+		if _, ok := nested[parts[0]]; !ok {
+			names = append(names, parts[0])
+			nested[parts[0]] = nil
+		}
+		if len(parts) == 2 {
+			nested[parts[0]] = append(nested[parts[0]], parts[1])
+		}
+	}
+	for _, name := range names {
+		if err := s.preload(refs, name, nested[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordRefs *Table, *[]Table, []*Table or []interface{} of pointers -> []interface{} of pointers
+func recordRefs(records interface{}) []interface{} {
+	if r, ok := records.([]interface{}); ok {
+		return r
+	}
+	v := reflect.ValueOf(records)
+	if v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Struct {
+		return []interface{}{records}
+	}
+	return itemRefs(records)
+}
+
+func (s *TableCache) preload(refs []interface{}, name string, nested []string) error {
+	rel, ok := s.schema.Relationships.Relations[name]
+	if !ok {
+		return fmt.Errorf("%w: %s.%s", ErrRelationNotFound, s.structName, name)
+	}
+	related, err := s.relatedCache(rel.FieldSchema.Name)
+	if err != nil {
+		return err
+	}
+	var children [][]interface{}
+	switch rel.Type {
+	case schema.BelongsTo:
+		children, err = s.preloadBelongsTo(refs, rel, related)
+	case schema.HasOne, schema.HasMany:
+		children, err = s.preloadHasMany(refs, rel, related)
+	case schema.Many2Many:
+		children, err = s.preloadMany2Many(refs, rel, related)
+	default:
+		err = fmt.Errorf("%w: %s.%s is %s", ErrRelationUnsupported, s.structName, name, rel.Type)
+	}
+	if err != nil {
+		return err
+	}
+	var loaded []interface{}
+	for i, ref := range refs {
+		fieldV := reflect.Indirect(reflect.ValueOf(ref)).FieldByName(rel.Field.Name)
+		loaded = append(loaded, setRelation(fieldV, children[i])...)
+	}
+	if len(nested) == 0 || len(loaded) == 0 {
+		return nil
+	}
+	return related.Preload(loaded, nested...)
+}
+
+func (s *TableCache) relatedCache(structName string) (*TableCache, error) {
+	var r *TableCache
+	if s.registry != nil {
+		r = s.registry.getCache(structName)
+	}
+	if r == nil {
+		return nil, fmt.Errorf("%w: %s", ErrCacheNotRegistered, structName)
+	}
+	return r, nil
+}
+
+// singleRef the only reference of relation, composite and polymorphic references are not supported
+func singleRef(rel *schema.Relationship, own bool) (*schema.Reference, error) {
+	var r *schema.Reference
+	for _, ref := range rel.References {
+		if ref.PrimaryValue != "" {
+			return nil, fmt.Errorf("%w: polymorphic %s", ErrRelationUnsupported, rel.Name)
+		}
+		if ref.OwnPrimaryKey != own {
+			continue
+		}
+		if r != nil {
+			return nil, fmt.Errorf("%w: composite %s", ErrRelationUnsupported, rel.Name)
+		}
+		r = ref
+	}
+	if r == nil {
+		return nil, fmt.Errorf("%w: %s has no reference", ErrRelationUnsupported, rel.Name)
+	}
+	return r, nil
+}
+
+// distinctValues field values of records, nil skipped. keys are stringified values of each record
+func (s *TableCache) distinctValues(records []interface{}, field string) (values []interface{}, keys []string) {
+	seen := make(map[string]bool, len(records))
+	keys = make([]string, len(records))
+	for i, record := range records {
+		v := s.cacheUtil.GetFieldValue(record, field)
+		keys[i] = s.cacheUtil.StringifyDeref(v)
+		if keys[i] == NullStr || seen[keys[i]] {
+			continue
+		}
+		seen[keys[i]] = true
+		values = append(values, reflect.Indirect(reflect.ValueOf(v)).Interface())
+	}
+	return values, keys
+}
+
+// getMany records of related by field values, in order of values. id field goes by List, others by unique index
+func (s *TableCache) getMany(field string, values []interface{}) ([]interface{}, error) {
+	if field == s.idField {
+		return s.ListPositional(values)
+	}
+	return s.GetByMany(field, values)
+}
+
+// preloadBelongsTo foreign key is in own table
+func (s *TableCache) preloadBelongsTo(refs []interface{}, rel *schema.Relationship, related *TableCache) ([][]interface{}, error) {
+	ref, err := singleRef(rel, false)
+	if err != nil {
+		return nil, err
+	}
+	values, keys := s.distinctValues(refs, ref.ForeignKey.Name)
+	parents, err := related.getMany(ref.PrimaryKey.Name, values)
+	if err != nil {
+		return nil, err
+	}
+	byValue := make(map[string]interface{}, len(values))
+	for i, v := range values {
+		if parents[i] != nil {
+			byValue[s.cacheUtil.Stringify(v)] = parents[i]
+		}
+	}
+	r := make([][]interface{}, len(refs))
+	for i, k := range keys {
+		if parent, ok := byValue[k]; ok {
+			r[i] = []interface{}{parent}
+		}
+	}
+	return r, nil
+}
+
+// preloadHasMany foreign key is in related table, which needs an index on it
+func (s *TableCache) preloadHasMany(refs []interface{}, rel *schema.Relationship, related *TableCache) ([][]interface{}, error) {
+	ref, err := singleRef(rel, true)
+	if err != nil {
+		return nil, err
+	}
+	values, keys := s.distinctValues(refs, ref.PrimaryKey.Name)
+	lists, err := related.ListByMany(ref.ForeignKey.Name, values)
+	if err != nil {
+		return nil, err
+	}
+	byValue := make(map[string][]interface{}, len(values))
+	for i, v := range values {
+		byValue[s.cacheUtil.Stringify(v)] = itemRefs(lists[i])
+	}
+	r := make([][]interface{}, len(refs))
+	for i, k := range keys {
+		r[i] = byValue[k]
+	}
+	return r, nil
+}
+
+// preloadMany2Many join rows -> related ids -> related records
+func (s *TableCache) preloadMany2Many(refs []interface{}, rel *schema.Relationship, related *TableCache) ([][]interface{}, error) {
+	ownRef, err := singleRef(rel, true)
+	if err != nil {
+		return nil, err
+	}
+	relRef, err := singleRef(rel, false)
+	if err != nil {
+		return nil, err
+	}
+	values, keys := s.distinctValues(refs, ownRef.PrimaryKey.Name)
+	joins, err := s.joinRows(rel, ownRef, relRef, values)
+	if err != nil {
+		return nil, err
+	}
+	var relValues []interface{}
+	seen := make(map[string]bool)
+	for _, join := range joins {
+		k := s.cacheUtil.StringifyDeref(join[1])
+		if k != NullStr && !seen[k] {
+			seen[k] = true
+			relValues = append(relValues, reflect.Indirect(reflect.ValueOf(join[1])).Interface())
+		}
+	}
+	records, err := related.getMany(relRef.PrimaryKey.Name, relValues)
+	if err != nil {
+		return nil, err
+	}
+	byRelValue := make(map[string]interface{}, len(relValues))
+	for i, v := range relValues {
+		if records[i] != nil {
+			byRelValue[s.cacheUtil.Stringify(v)] = records[i]
+		}
+	}
+	byValue := make(map[string][]interface{}, len(values))
+	for _, join := range joins {
+		if record, ok := byRelValue[s.cacheUtil.StringifyDeref(join[1])]; ok {
+			k := s.cacheUtil.StringifyDeref(join[0])
+			byValue[k] = append(byValue[k], record)
+		}
+	}
+	r := make([][]interface{}, len(refs))
+	for i, k := range keys {
+		r[i] = byValue[k]
+	}
+	return r, nil
+}
+
+// joinRows [own value, related value] of join table rows referencing values
+func (s *TableCache) joinRows(rel *schema.Relationship, ownRef, relRef *schema.Reference, values []interface{}) ([][2]interface{}, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	var r [][2]interface{}
+	if s.registry != nil {
+		if joinCache := s.registry.getCache(rel.JoinTable.Name); joinCache != nil {
+			lists, err := joinCache.ListByMany(ownRef.ForeignKey.Name, values)
+			if err != nil {
+				return nil, err
+			}
+			for _, list := range lists {
+				for _, join := range itemRefs(list) {
+					r = append(r, [2]interface{}{
+						s.cacheUtil.GetFieldValue(join, ownRef.ForeignKey.Name),
+						s.cacheUtil.GetFieldValue(join, relRef.ForeignKey.Name),
+					})
+				}
+			}
+			return r, nil
+		}
+	}
+	var rows []map[string]interface{}
+	err := s.db.Table(rel.JoinTable.Table).Select(ownRef.ForeignKey.DBName, relRef.ForeignKey.DBName).
+		Where(ownRef.ForeignKey.DBName+" IN ?", values).Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		r = append(r, [2]interface{}{row[ownRef.ForeignKey.DBName], row[relRef.ForeignKey.DBName]})
+	}
+	return r, nil
+}
+
+// setRelation set items (pointers) into association field, struct, *struct, []struct or []*struct.
+// return pointers of the set values, for nested preload
+func setRelation(fieldV reflect.Value, items []interface{}) []interface{} {
+	switch fieldV.Kind() {
+	case reflect.Slice:
+		elemIsPtr := fieldV.Type().Elem().Kind() == reflect.Ptr
+		slice := reflect.MakeSlice(fieldV.Type(), 0, len(items))
+		for _, item := range items {
+			v := reflect.ValueOf(item)
+			if !elemIsPtr {
+				v = v.Elem()
+			}
+			slice = reflect.Append(slice, v)
+		}
+		fieldV.Set(slice)
+		r := make([]interface{}, slice.Len())
+		for i := range r {
+			if elemIsPtr {
+				r[i] = slice.Index(i).Interface()
+			} else {
+				r[i] = slice.Index(i).Addr().Interface()
+			}
+		}
+		return r
+	case reflect.Ptr:
+		if len(items) == 0 {
+			fieldV.Set(reflect.Zero(fieldV.Type()))
+			return nil
+		}
+		fieldV.Set(reflect.ValueOf(items[0]))
+		return items[:1]
+	}
+	if len(items) == 0 {
+		fieldV.Set(reflect.Zero(fieldV.Type()))
+		return nil
+	}
+	fieldV.Set(reflect.ValueOf(items[0]).Elem())
+	return []interface{}{fieldV.Addr().Interface()}
+}
@@ -0,0 +1,158 @@
+package tablecache
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// multi indexes are redis sets of ids. the sentinel member marks a loaded set, so that an empty group is a hit too
+const setSentinel = NullStr
+
+// KEYS: set. ARGV: ids. add ids only if set is loaded, a partial set must not look like a hit
+var saddIfExistsScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return redis.call('SADD', KEYS[1], unpack(ARGV))
+end
+return 0
+`)
+
+// getSetMembers SSCAN index set in chunks of batchSize. ok is false if set is not loaded
+func (s *TableCache) getSetMembers(key string) ([]string, bool, error) {
+	var r []string
+	seen := make(map[string]bool)
+	var cursor uint64
+	for {
+		members, next, err := s.redisClient.SScan(s.redisCtx, key, cursor, "", int64(s.batchSize)).Result()
+		if err != nil {
+			return nil, false, err
+		}
+		for _, v := range members {
+			if !seen[v] {
+				seen[v] = true
+				r = append(r, v)
+			}
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if len(r) == 0 {
+		return nil, false, nil
+	}
+	return s.parseSetMembers(r), true, nil
+}
+
+// parseSetMembers drop sentinel and sort ids
+func (s *TableCache) parseSetMembers(members []string) []string {
+	r := make([]string, 0, len(members))
+	for _, v := range members {
+		if v != setSentinel {
+			r = append(r, v)
+		}
+	}
+	if s.IsIDInteger() {
+		sort.Slice(r, func(i, j int) bool {
+			a, _ := strconv.ParseUint(r[i], 10, 64)
+			b, _ := strconv.ParseUint(r[j], 10, 64)
+			return a < b
+		})
+	} else {
+		sort.Strings(r)
+	}
+	return r
+}
+
+// parseIDStrs []string -> ids in id type
+func (s *TableCache) parseIDStrs(strs []string) ([]interface{}, error) {
+	r := make([]interface{}, len(strs))
+	for i, v := range strs {
+		id, err := s.parseID(v)
+		if err != nil {
+			return nil, err
+		}
+		r[i] = id
+	}
+	return r, nil
+}
+
+// pipeSetMembers replace index set by ids of records
+func (s *TableCache) pipeSetMembers(pipe redis.Pipeliner, key string, records []interface{}) {
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i] = s.cacheUtil.Stringify(s.GetID(record))
+	}
+	s.pipeSetIDs(pipe, key, ids)
+}
+
+// pipeSetIDs replace index set by ids, sentinel included
+func (s *TableCache) pipeSetIDs(pipe redis.Pipeliner, key string, ids []string) {
+	members := make([]interface{}, 0, len(ids)+1)
+	members = append(members, setSentinel)
+	for _, id := range ids {
+		members = append(members, id)
+	}
+	pipe.Del(s.redisCtx, key)
+	pipe.SAdd(s.redisCtx, key, members...)
+	// ttl 0 means never expire, EXPIRE 0 would delete the set
+	if s.ttl > 0 {
+		pipe.Expire(s.redisCtx, key, s.ttl)
+	}
+}
+
+// updateMultiIndexes move ids between index sets. news are rows after writing, olds are rows before writing or deleted rows
+func (s *TableCache) updateMultiIndexes(news []interface{}, olds []interface{}) error {
+	type change struct {
+		id       string
+		old, new interface{}
+	}
+	changes := make(map[string]*change)
+	var order []string
+	get := func(id string) *change {
+		if c, ok := changes[id]; ok {
+			return c
+		}
+		c := &change{id: id}
+		changes[id] = c
+		order = append(order, id)
+		return c
+	}
+	for _, v := range olds {
+		get(s.cacheUtil.Stringify(s.GetID(v))).old = v
+	}
+	for _, v := range news {
+		get(s.cacheUtil.Stringify(s.GetID(v))).new = v
+	}
+	return s.pipelined(len(order), func(pipe redis.Pipeliner, i int) error {
+		c := changes[order[i]]
+		for _, idx := range s.Indexes {
+			if idx.IsUnique() {
+				continue
+			}
+			var oldKey, newKey string
+			if c.old != nil {
+				oldKey = s.getIndexRedisKey(idx, s.pick(c.old, idx.Fields))
+			}
+			if c.new != nil {
+				newKey = s.getIndexRedisKey(idx, s.pick(c.new, idx.Fields))
+			}
+			if oldKey == newKey {
+				if c.old == nil || c.new == nil {
+					continue
+				}
+				// same group, make sure id is in the set
+				saddIfExistsScript.Eval(s.redisCtx, pipe, []string{newKey}, c.id)
+				continue
+			}
+			if oldKey != "" {
+				pipe.SRem(s.redisCtx, oldKey, c.id)
+			}
+			if newKey != "" {
+				saddIfExistsScript.Eval(s.redisCtx, pipe, []string{newKey}, c.id)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,190 @@
+package tablecache
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// Condition values of declared indexes, matched if any of them matches (OR). eg. Eq("ProjectID", 5), In("Status", []string{"active", "new"})
+type Condition struct {
+	Any []map[string]interface{}
+}
+
+//Eq one index value, eg. Eq("ProjectID", 5, "Status", "active")
+func Eq(index ...interface{}) Condition {
+	return Condition{Any: []map[string]interface{}{argsToMap(index...)}}
+}
+
+//In field is any of values, eg. In("Status", []string{"active", "new"})
+func In(field string, values interface{}) Condition {
+	vs := toInterfaces(values)
+	r := Condition{Any: make([]map[string]interface{}, len(vs))}
+	for i, v := range vs {
+		r.Any[i] = map[string]interface{}{field: v}
+	}
+	return r
+}
+
+//Or any of conditions, eg. Or(Eq("OwnerID", 1), Eq("AssigneeID", 1))
+func Or(conditions ...Condition) Condition {
+	var r Condition
+	for _, c := range conditions {
+		r.Any = append(r.Any, c.Any...)
+	}
+	return r
+}
+
+// KEYS: index sets. ARGV: number of keys of each OR group.
+// SUNION each group then intersect groups, plain SINTER if every group has one key. nil if any set is not loaded
+var listWhereScript = redis.NewScript(`
+for _, key in ipairs(KEYS) do
+	if redis.call('EXISTS', key) == 0 then
+		return false
+	end
+end
+local single = true
+for _, n in ipairs(ARGV) do
+	if tonumber(n) > 1 then
+		single = false
+	end
+end
+if single then
+	return redis.call('SINTER', unpack(KEYS))
+end
+local result = nil
+local pos = 1
+for _, n in ipairs(ARGV) do
+	n = tonumber(n)
+	local members = redis.call('SUNION', unpack(KEYS, pos, pos + n - 1))
+	pos = pos + n
+	local next = {}
+	for _, m in ipairs(members) do
+		if result == nil or result[m] then
+			next[m] = true
+		end
+	end
+	result = next
+end
+local r = {}
+for m in pairs(result) do
+	table.insert(r, m)
+end
+return r
+`)
+
+// max tries of loading cold index sets before ListWhere gives up the cache and queries db
+const listWhereTries = 3
+
+//ListWhere records matching all conditions (AND), through multi index sets. type is *[]Table, in order of id.
+// Cold index sets are loaded from db. conditions on undeclared or unique indexes are answered by db directly
+func (s *TableCache) ListWhere(ctx context.Context, conditions ...Condition) (interface{}, error) {
+	var keys []string
+	sizes := make([]interface{}, 0, len(conditions))
+	keyValues := make(map[string]map[string]interface{})
+	for _, c := range conditions {
+		if len(c.Any) == 0 {
+			return s.FactoryListRef(), nil
+		}
+		for _, values := range c.Any {
+			idx, err := s.findIndex(values)
+			if err != nil || idx.IsUnique() {
+				return s.listWhereDB(ctx, conditions)
+			}
+			values = canonicalValues(idx, values)
+			key := s.getIndexRedisKey(idx, values)
+			keys = append(keys, key)
+			keyValues[key] = values
+		}
+		sizes = append(sizes, len(c.Any))
+	}
+	if len(keys) == 0 {
+		return s.listWhereDB(ctx, conditions)
+	}
+	for i := 0; i < listWhereTries; i++ {
+		members, err := listWhereScript.Run(ctx, s.redisClient, keys, sizes...).StringSlice()
+		if err == nil {
+			ids, err := s.parseIDStrs(s.parseSetMembers(members))
+			if err != nil {
+				return nil, err
+			}
+			return s.List(ids)
+		}
+		if err != redis.Nil {
+			return nil, err
+		}
+		err = s.loadColdSets(ctx, keys, keyValues)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s.listWhereDB(ctx, conditions)
+}
+
+// loadColdSets load index sets which are not in redis from db
+func (s *TableCache) loadColdSets(ctx context.Context, keys []string, keyValues map[string]map[string]interface{}) error {
+	exists := make([]*redis.IntCmd, len(keys))
+	_, err := s.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			exists[i] = pipe.Exists(ctx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	idField := s.schema.LookUpField(s.idField)
+	cold := make(map[string][]string)
+	for i, key := range keys {
+		if exists[i].Val() > 0 {
+			continue
+		}
+		if _, ok := cold[key]; ok {
+			continue
+		}
+		ids := reflect.New(reflect.SliceOf(idField.FieldType)).Interface()
+		err = s.db.WithContext(ctx).Model(s.FactorySingleRef()).Where(s.toColumns(keyValues[key])).Pluck(idField.DBName, ids).Error
+		if err != nil {
+			return err
+		}
+		idStrs := make([]string, 0)
+		for _, id := range toInterfaces(ids) {
+			idStrs = append(idStrs, s.cacheUtil.Stringify(id))
+		}
+		cold[key] = idStrs
+	}
+	if len(cold) == 0 {
+		return nil
+	}
+	_, err = s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, ids := range cold {
+			s.pipeSetIDs(pipe, key, ids)
+		}
+		return nil
+	})
+	return err
+}
+
+// listWhereDB query conditions in db, ordered by id
+func (s *TableCache) listWhereDB(ctx context.Context, conditions []Condition) (interface{}, error) {
+	r := s.FactoryListRef()
+	tx := s.db.WithContext(ctx)
+	for _, c := range conditions {
+		if len(c.Any) == 0 {
+			return r, nil
+		}
+		var group *gorm.DB
+		for _, values := range c.Any {
+			if group == nil {
+				group = s.db.Where(s.toColumns(values))
+			} else {
+				group = group.Or(s.toColumns(values))
+			}
+		}
+		tx = tx.Where(group)
+	}
+	err := tx.Order(s.schema.LookUpField(s.idField).DBName).Find(r).Error
+	return r, err
+}
@@ -0,0 +1,127 @@
+package tablecache
+
+import (
+	"errors"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// WritePolicy how TableCache updates cache after writes
+type WritePolicy int
+
+const (
+	// WriteInvalidate delete keys of written rows, next read goes to db
+	WriteInvalidate WritePolicy = iota
+	// WriteThrough set written rows under id keys and fix up index keys
+	WriteThrough
+	// WriteThroughReload same as WriteThrough, but re-read rows from db first, for db defaults and triggers
+	WriteThroughReload
+)
+
+func (s *TableCache) SetWritePolicy(writePolicy WritePolicy) {
+	s.writePolicy = writePolicy
+}
+
+func (s *TableCache) GetWritePolicy() WritePolicy {
+	return s.writePolicy
+}
+
+// takeFromDB nil if not found
+func (s *TableCache) takeFromDB(id interface{}) (interface{}, error) {
+	r := s.FactorySingleRef()
+	err := s.db.Take(r, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return r, err
+}
+
+// afterWrite update bloom filters and cache of written rows by write policy. olds are rows before writing, if known
+func (s *TableCache) afterWrite(valueRefs []interface{}, olds []interface{}) error {
+	err := s.bloomAdd(valueRefs...)
+	if err != nil {
+		return err
+	}
+	switch s.writePolicy {
+	case WriteThrough:
+		return s.writeThrough(valueRefs, olds)
+	case WriteThroughReload:
+		list, err := s.fetchIn(s.idField, s.idsOf(valueRefs))
+		if err != nil {
+			return err
+		}
+		fresh := s.mapByID(list)
+		records := make([]interface{}, 0, len(valueRefs))
+		var gone []interface{}
+		for _, v := range valueRefs {
+			if record, ok := fresh[s.cacheUtil.Stringify(s.GetID(v))]; ok {
+				records = append(records, record)
+			} else {
+				gone = append(gone, v)
+			}
+		}
+		if err := s.invalidate(nil, gone); err != nil {
+			return err
+		}
+		return s.writeThrough(records, olds)
+	}
+	return s.invalidate(valueRefs, olds)
+}
+
+// invalidate delete id keys and unique index keys of news and olds, move ids between multi index sets
+func (s *TableCache) invalidate(news []interface{}, olds []interface{}) error {
+	err := s.delKeys(s.cacheKeysOf(append(append([]interface{}{}, news...), olds...), false))
+	if err != nil {
+		return err
+	}
+	return s.updateMultiIndexes(news, olds)
+}
+
+func (s *TableCache) idsOf(valueRefs []interface{}) []interface{} {
+	r := make([]interface{}, len(valueRefs))
+	for i, v := range valueRefs {
+		r[i] = s.GetID(v)
+	}
+	return r
+}
+
+// writeThrough set records under id keys, point unique index keys to them, and move ids between multi index sets.
+// olds are rows before writing, index keys of their values are fixed up too
+func (s *TableCache) writeThrough(records []interface{}, olds []interface{}) error {
+	oldByID := make(map[string]interface{}, len(olds))
+	for _, v := range olds {
+		oldByID[s.cacheUtil.Stringify(s.GetID(v))] = v
+	}
+	err := s.redisClient.Del(s.redisCtx, s.getMaxRedisKey()).Err()
+	if err != nil {
+		return err
+	}
+	err = s.pipelined(len(records), func(pipe redis.Pipeliner, i int) error {
+		record := records[i]
+		id := s.GetID(record)
+		jsonStr, err := s.marshaller.Marshal(record)
+		if err != nil {
+			return err
+		}
+		pipe.Set(s.redisCtx, s.getIDRedisKey(id), jsonStr, s.ttl)
+		old := oldByID[s.cacheUtil.Stringify(id)]
+		for _, idx := range s.Indexes {
+			if !idx.IsUnique() {
+				continue
+			}
+			key := s.getIndexRedisKey(idx, s.pick(record, idx.Fields))
+			pipe.Set(s.redisCtx, key, s.cacheUtil.Stringify(id), s.ttl)
+			if old != nil {
+				if oldKey := s.getIndexRedisKey(idx, s.pick(old, idx.Fields)); oldKey != key {
+					pipe.Del(s.redisCtx, oldKey)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.updateMultiIndexes(records, olds)
+}
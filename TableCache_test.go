@@ -1,9 +1,11 @@
 package tablecache_test
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -66,7 +68,7 @@ func (s *TableCacheTest) SetupTest() {
 	redisGorm := tablecache.NewRedisGorm(GetRedis(), GetMysql(), 3*time.Minute, "ID", "test",
 		func() interface{} { return &User{} }, func() interface{} { return &([]User{}) })
 	redisGorm.GetDB().AutoMigrate(tables...)
-	s.users = tablecache.NewTableCache(redisGorm, "User", [][]string{{"Name"}})
+	s.users = tablecache.NewTableCache(redisGorm, "User", []tablecache.Index{tablecache.Multi("Name")})
 }
 
 func (s *TableCacheTest) TestGet() {
@@ -87,27 +89,364 @@ func (s *TableCacheTest) TestList() {
 	u, err = s.users.List([]uint64{12, 120})
 	s.Nil(err)
 	fmt.Println(*u.(*[]User))
+	rs, err := s.users.ListPositional([]uint64{15, 123})
+	s.Nil(err)
+	s.Len(rs, 2)
+	fmt.Println(rs)
 }
 
 func (s *TableCacheTest) TestGetBy() {
-	u, err := s.users.GetBy("ID", 14)
+	_, err := s.users.GetBy("Name", "haha")
+	s.ErrorIs(err, tablecache.ErrIndexNotUnique)
+	_, err = s.users.GetBy("ID", 14)
+	s.ErrorIs(err, tablecache.ErrIndexNotDeclared)
+}
+
+func (s *TableCacheTest) TestListBy() {
+	u, err := s.users.ListBy("Name", "haha")
 	s.Nil(err)
 	fmt.Println(u)
-	u, err = s.users.GetBy("ID", 100)
+	u, err = s.users.ListBy("name", "haha")
 	s.Nil(err)
 	fmt.Println(u)
+	_, err = s.users.ListBy("ID", 12)
+	s.ErrorIs(err, tablecache.ErrIndexNotDeclared)
 }
 
-func (s *TableCacheTest) TestListBy() {
-	u, err := s.users.ListBy("ID", 16)
+func (s *TableCacheTest) TestListByFieldCase() {
+	u := User{Name: "case1"}
+	s.Nil(s.users.Create(&u))
+	list, err := s.users.ListBy("name", "case1")
 	s.Nil(err)
-	fmt.Println(u)
-	u, err = s.users.ListBy("ID", 12)
+	s.Len(*list.(*[]User), 1)
+	u.Name = "case2"
+	s.Nil(s.users.Update(&u, "Name"))
+	// the write invalidates the set read through lower case field
+	list, err = s.users.ListBy("name", "case1")
 	s.Nil(err)
-	fmt.Println(u)
-	u, err = s.users.ListBy("ID", 12)
+	s.Empty(*list.(*[]User))
+	s.Nil(s.users.Delete(u.ID))
+}
+
+func (s *TableCacheTest) TestListByNoTTL() {
+	redisGorm := tablecache.NewRedisGorm(GetRedis(), GetMysql(), 0, "ID", "test",
+		func() interface{} { return &User{} }, func() interface{} { return &([]User{}) })
+	users := tablecache.NewTableCache(redisGorm, "User", []tablecache.Index{tablecache.Multi("Name")})
+	u := User{Name: "nottl"}
+	s.Nil(users.Create(&u))
+	r, err := users.ListBy("Name", "nottl")
 	s.Nil(err)
-	fmt.Println(u)
+	s.NotEmpty(*r.(*[]User))
+	// second read is served by the index set, which must still exist
+	r, err = users.ListBy("Name", "nottl")
+	s.Nil(err)
+	s.NotEmpty(*r.(*[]User))
+	s.Nil(users.Delete(u.ID))
+}
+
+func (s *TableCacheTest) TestListByMany() {
+	r, err := s.users.ListByMany("Name", []string{"haha", "nobody"})
+	s.Nil(err)
+	s.Len(r, 2)
+	s.Empty(*r[1].(*[]User))
+	fmt.Println(r[0])
+	_, err = s.users.GetByMany("Name", []string{"haha"})
+	s.ErrorIs(err, tablecache.ErrIndexNotUnique)
+}
+
+func (s *TableCacheTest) TestListByManyCollation() {
+	u := User{Name: "collate1"}
+	s.Nil(s.users.Create(&u))
+	// mysql compares names case insensitively, the row found for the upper case input is kept, not cached as missing
+	for i := 0; i < 2; i++ {
+		r, err := s.users.ListByMany("Name", []string{"COLLATE1", "nobody"})
+		s.Nil(err)
+		s.Len(*r[0].(*[]User), 1)
+		s.Empty(*r[1].(*[]User))
+	}
+	s.Nil(s.users.Delete(u.ID))
+}
+
+func (s *TableCacheTest) TestBloom() {
+	s.users.EnableBloom(10000, 0.01)
+	s.Nil(s.users.WarmBloom())
+	u := User{Name: "bloom"}
+	s.Nil(s.users.Create(&u))
+	nu, err := s.users.Get(u.ID)
+	s.Nil(err)
+	s.NotNil(nu)
+}
+
+func (s *TableCacheTest) TestWriteThrough() {
+	s.users.SetWritePolicy(tablecache.WriteThrough)
+	u := User{Name: "through"}
+	s.Nil(s.users.Create(&u))
+	u.Name = "through2"
+	s.Nil(s.users.Update(&u, "Name"))
+	nu, err := s.users.Get(u.ID)
+	s.Nil(err)
+	s.Equal("through2", nu.(*User).Name)
+	list, err := s.users.ListBy("Name", "through2")
+	s.Nil(err)
+	s.Len(*list.(*[]User), 1)
+}
+
+func (s *TableCacheTest) TestSetIndex() {
+	u := User{Name: "set1"}
+	s.Nil(s.users.Create(&u))
+	list, err := s.users.ListBy("Name", "set1")
+	s.Nil(err)
+	n := len(*list.(*[]User))
+	u.Name = "set2"
+	s.Nil(s.users.Update(&u, "Name"))
+	list, err = s.users.ListBy("Name", "set1")
+	s.Nil(err)
+	s.Len(*list.(*[]User), n-1)
+	s.Nil(s.users.Delete(u.ID))
+	list, err = s.users.ListBy("Name", "set2")
+	s.Nil(err)
+	for _, v := range *list.(*[]User) {
+		s.NotEqual(u.ID, v.ID)
+	}
+}
+
+func (s *TableCacheTest) TestListWhere() {
+	u := User{Name: "where"}
+	s.Nil(s.users.Create(&u))
+	list, err := s.users.ListWhere(context.Background(), tablecache.In("Name", []string{"where", "nobody"}))
+	s.Nil(err)
+	s.NotEmpty(*list.(*[]User))
+	list, err = s.users.ListWhere(context.Background(), tablecache.Eq("Name", "where"), tablecache.Eq("ID", u.ID))
+	s.Nil(err)
+	s.Len(*list.(*[]User), 1)
+}
+
+type Team struct {
+	Base
+	Name string `gorm:"type:varchar(100) not null;"`
+}
+
+type Member struct {
+	Base
+	TeamID uint64 `gorm:"not null;FK:Team"`
+	Team   *Team
+}
+
+// teamDDL migrate teams and members, and add them to a new DDL
+func (s *TableCacheTest) teamDDL() *tablecache.DDL {
+	db := s.users.GetDB()
+	s.Nil(db.AutoMigrate(&Team{}, &Member{}))
+	ddl := tablecache.NewDDL(db)
+	s.Nil(ddl.AddTables(&Team{}, &Member{}))
+	return ddl
+}
+
+// teamCaches caches of teams and members, linked by a registry of ddl
+func (s *TableCacheTest) teamCaches(ddl *tablecache.DDL) (*tablecache.TableCache, *tablecache.TableCache) {
+	db := s.users.GetDB()
+	teams := tablecache.NewTableCache(tablecache.NewRedisGorm(GetRedis(), db, 3*time.Minute, "ID", "test",
+		func() interface{} { return &Team{} }, func() interface{} { return &([]Team{}) }), "Team", nil)
+	members := tablecache.NewTableCache(tablecache.NewRedisGorm(GetRedis(), db, 3*time.Minute, "ID", "test",
+		func() interface{} { return &Member{} }, func() interface{} { return &([]Member{}) }), "Member", []tablecache.Index{tablecache.Multi("TeamID")})
+	registry, err := tablecache.NewCacheRegistry(ddl)
+	s.Nil(err)
+	registry.Register(teams, members)
+	return teams, members
+}
+
+func (s *TableCacheTest) TestCascade() {
+	ddl := s.teamDDL()
+	s.Nil(ddl.MakeFKs())
+	teams, members := s.teamCaches(ddl)
+	team := Team{Name: "cascade"}
+	s.Nil(teams.Create(&team))
+	member := Member{TeamID: team.ID}
+	s.Nil(members.Create(&member))
+	list, err := members.ListBy("TeamID", team.ID)
+	s.Nil(err)
+	s.Len(*list.(*[]Member), 1)
+	// db deletes the member with its team, the cached member goes too
+	s.Nil(teams.Delete(team.ID))
+	m, err := members.Get(member.ID)
+	s.Nil(err)
+	s.Nil(m)
+	list, err = members.ListBy("TeamID", team.ID)
+	s.Nil(err)
+	s.Empty(*list.(*[]Member))
+}
+
+func (s *TableCacheTest) TestPreload() {
+	ddl := s.teamDDL()
+	teams, members := s.teamCaches(ddl)
+	team := Team{Name: "preload"}
+	s.Nil(teams.Create(&team))
+	member := Member{TeamID: team.ID}
+	s.Nil(members.Create(&member))
+	list, err := members.ListBy("TeamID", team.ID)
+	s.Nil(err)
+	s.Nil(members.Preload(list, "Team"))
+	s.Equal("preload", (*list.(*[]Member))[0].Team.Name)
+	s.Nil(members.Delete(member.ID))
+	s.Nil(teams.Delete(team.ID))
+}
+
+func (s *TableCacheTest) TestMakeFKs() {
+	ddl := s.teamDDL()
+	s.Nil(ddl.MakeFKs())
+	// existing constraints are skipped
+	s.Nil(ddl.MakeFKs())
+	fks, err := ddl.ForeignKeys("members")
+	s.Nil(err)
+	s.Len(fks, 1)
+	s.Equal([]string{"team_id"}, fks[0].Columns)
+	s.Equal("teams", fks[0].RefTable)
+}
+
+func (s *TableCacheTest) TestPlan() {
+	ddl := s.teamDDL()
+	s.Nil(ddl.MakeFKs())
+	plan, err := ddl.Plan()
+	s.Nil(err)
+	for _, op := range plan.Ops {
+		s.NotEqual(tablecache.PlanAdd, op.Kind)
+		// the FK tag adopts fk_members_team of the belongsTo association, it is not dropped
+		s.NotEqual(tablecache.PlanDrop, op.Kind)
+	}
+	stmts, err := plan.SQL()
+	s.Nil(err)
+	fmt.Println(stmts)
+}
+
+func (s *TableCacheTest) TestScript() {
+	ddl := s.teamDDL()
+	s.Nil(ddl.MakeFKs())
+	// constraints exist, nothing to script
+	script, err := ddl.Script(ddl.MakeFKs)
+	s.Nil(err)
+	s.Empty(script.SQL())
+	s.Empty(script.RollbackSQL())
+}
+
+type Author struct {
+	Base
+	LatestBookID *uint64 `gorm:"FK:Book"`
+}
+
+type Book struct {
+	Base
+	AuthorID uint64 `gorm:"not null;FK:Author"`
+}
+
+func (s *TableCacheTest) TestGraph() {
+	ddl := tablecache.NewDDL(s.users.GetDB())
+	s.Nil(ddl.AddTables(&Team{}, &Member{}))
+	graph, err := ddl.Graph()
+	s.Nil(err)
+	order, err := graph.CreationOrder()
+	s.Nil(err)
+	s.Equal([]string{"teams", "members"}, order)
+	order, err = graph.DropOrder()
+	s.Nil(err)
+	s.Equal([]string{"members", "teams"}, order)
+	s.Empty(graph.Cycles())
+
+	cyclic := tablecache.NewDDL(s.users.GetDB())
+	s.Nil(cyclic.AddTables(&Author{}, &Book{}))
+	graph, err = cyclic.Graph()
+	s.Nil(err)
+	s.Equal([][]string{{"authors", "books"}}, graph.Cycles())
+	_, err = graph.CreationOrder()
+	s.ErrorIs(err, tablecache.ErrFKCycle)
+	_, err = graph.DropOrder()
+	s.ErrorIs(err, tablecache.ErrFKCycle)
+}
+
+type Shipment struct {
+	TenantID uint64 `gorm:"primaryKey;autoIncrement:false"`
+	Number   uint64 `gorm:"primaryKey;autoIncrement:false"`
+}
+
+type Parcel struct {
+	Base
+	ShipmentTenant uint64 `gorm:"FK:Shipment.TenantID,name=fk_parcels_shipment"`
+	ShipmentNumber uint64 `gorm:"FK:Shipment.Number,name=fk_parcels_shipment"`
+}
+
+func (s *TableCacheTest) TestExport() {
+	ddl := s.teamDDL()
+	_, members := s.teamCaches(ddl)
+	var diagram strings.Builder
+	s.Nil(ddl.ExportMermaid(&diagram, members))
+	s.Contains(diagram.String(), "members }o--|| teams")
+	fmt.Println(diagram.String())
+
+	composite := tablecache.NewDDL(s.users.GetDB())
+	s.Nil(composite.AddTables(&Shipment{}, &Parcel{}))
+	var dot strings.Builder
+	s.Nil(composite.ExportDOT(&dot))
+	// one edge per column pair
+	s.Equal(2, strings.Count(dot.String(), " -> "))
+	s.Contains(dot.String(), `"parcels":"shipment_tenant" -> "shipments":"tenant_id"`)
+	s.Contains(dot.String(), `"parcels":"shipment_number" -> "shipments":"number"`)
+}
+
+func (s *TableCacheTest) TestCheckIndexes() {
+	ddl := s.teamDDL()
+	_, members := s.teamCaches(ddl)
+	reports, err := members.CheckIndexes(context.Background(), true)
+	s.Nil(err)
+	s.Len(reports, 1)
+	s.NotEmpty(reports[0].DBIndex)
+}
+
+func (s *TableCacheTest) TestFKAdoptsAssociation() {
+	db := s.users.GetDB()
+	ddl := tablecache.NewDDL(db)
+	ddl.AlterChanged = true
+	s.Nil(ddl.AddTables(&Team{}, &Member{}))
+	for i := 0; i < 2; i++ {
+		s.Nil(db.AutoMigrate(&Team{}, &Member{}))
+		s.Nil(ddl.MakeFKs())
+	}
+	fks, err := ddl.ForeignKeys("members")
+	s.Nil(err)
+	// one key per column set, under gorm's association name
+	columns := make(map[string]int)
+	for _, fk := range fks {
+		columns[strings.Join(fk.Columns, ",")]++
+	}
+	s.Equal(map[string]int{"team_id": 1}, columns)
+	s.Equal("fk_members_team", fks[0].Name)
+}
+
+type Account struct {
+	Base
+	Email string `gorm:"type:varchar(100) not null;uniqueIndex"`
+	Age   int    `gorm:"check:chk_accounts_age,age >= 0"`
+}
+
+type Post struct {
+	Base
+	AuthorEmail *string `gorm:"type:varchar(100);FK:Account.Email,onDelete=SET NULL,name=fk_posts_author"`
+}
+
+func (s *TableCacheTest) TestFKTags() {
+	db := s.users.GetDB()
+	s.Nil(db.AutoMigrate(&Account{}, &Post{}))
+	ddl := tablecache.NewDDL(db)
+	s.Nil(ddl.AddTables(&Account{}, &Post{}))
+	fks, err := ddl.DeclaredFKs()
+	s.Nil(err)
+	s.Equal([]string{"email"}, fks[0].RefColumns)
+	s.Nil(ddl.MakeFKs())
+	s.Nil(ddl.MakeFKs())
+	fks, err = ddl.ForeignKeys("posts")
+	s.Nil(err)
+	s.Equal("fk_posts_author", fks[0].Name)
+	checks, err := ddl.Checks("accounts")
+	s.Nil(err)
+	s.NotEmpty(checks)
+	s.NotNil(db.Create(&Account{Email: "negative", Age: -1}).Error)
 }
 
 func (s *TableCacheTest) TestCreate() {
@@ -122,8 +461,8 @@ func (s *TableCacheTest) TestCreate() {
 	fmt.Println(newUser)
 }
 func (s *TableCacheTest) TestCreateMany() {
-	u := User{Name: "haha"}
-	us := []User{u}
+	s.users.SetBatchSize(1)
+	us := []User{{Name: "haha"}, {Name: "hehe"}}
 	err := s.users.CreateMany(&us)
 	s.Nil(err)
 	fmt.Println(us)
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"fmt"
+	"go/format"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/daqiancode/tablecache"
+	"gorm.io/gorm/schema"
+)
+
+// generator models of tables read through DDL catalog
+type generator struct {
+	ddl    *tablecache.DDL
+	naming schema.Namer
+	pkg    string
+	names  map[string]string // table: model name
+}
+
+// model struct of one table
+type model struct {
+	table     string
+	name      string
+	fields    []*field
+	byColumn  map[string]*field
+	pk        []*field
+	indexes   []tablecache.Index
+	comments  []string // constraints which tags can not express
+	usesTime  bool
+	tableName bool // TableName method needed, naming strategy gives another table name
+}
+
+type field struct {
+	column tablecache.DBColumn
+	name   string
+	goType string
+	tags   []string
+}
+
+var (
+	identUnsafe = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+	// names which gorm check tags accept, others are taken as part of the expression
+	checkNameRe = regexp.MustCompile(`^[A-Za-z-_]+$`)
+)
+
+// fieldName go name of column, eg. project_id -> ProjectID
+func fieldName(column string) string {
+	name := schema.NamingStrategy{SingularTable: true}.SchemaName(identUnsafe.ReplaceAllString(column, "_"))
+	name = strings.ReplaceAll(name, "_", "")
+	if name == "" || !(name[0] >= 'A' && name[0] <= 'Z') {
+		name = "F" + name
+	}
+	return name
+}
+
+// goType go type of column, nullable columns are pointers
+func goType(dialect string, c tablecache.DBColumn) string {
+	t := strings.ToLower(strings.TrimSpace(c.Type))
+	unsigned := strings.Contains(t, "unsigned")
+	base := t
+	if i := strings.IndexAny(base, "( "); i >= 0 {
+		base = base[:i]
+	}
+	intType := func(bits string) string {
+		if unsigned {
+			return "uint" + bits
+		}
+		return "int" + bits
+	}
+	var r string
+	switch {
+	case base == "bool" || base == "boolean" || strings.HasPrefix(t, "tinyint(1)"):
+		r = "bool"
+	case base == "tinyint":
+		r = intType("8")
+	case base == "smallint" || base == "int2" || base == "smallserial":
+		r = intType("16")
+	case base == "integer" && dialect == tablecache.DialectSQLite:
+		r = intType("64")
+	case base == "mediumint" || base == "int" || base == "integer" || base == "int4" || base == "serial":
+		r = intType("32")
+	case base == "bigint" || base == "int8" || base == "bigserial":
+		r = intType("64")
+	case base == "float" || base == "float4" || base == "real" && dialect == tablecache.DialectPostgres:
+		r = "float32"
+	case base == "double" || base == "float8" || base == "real" || base == "decimal" || base == "numeric":
+		r = "float64"
+	case base == "date" || base == "datetime" || strings.HasPrefix(base, "timestamp"):
+		r = "time.Time"
+	case strings.HasSuffix(base, "blob") || strings.HasSuffix(base, "binary") || base == "bytea":
+		return "[]byte"
+	default:
+		r = "string"
+	}
+	if c.Nullable {
+		return "*" + r
+	}
+	return r
+}
+
+// Generate go source of models of tables, all tables if empty
+func (s *generator) Generate(tables []string) ([]byte, error) {
+	var err error
+	if len(tables) == 0 {
+		if tables, err = s.ddl.Tables(); err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(tables)
+	if s.names, err = s.modelNames(tables); err != nil {
+		return nil, err
+	}
+	var models []*model
+	byTable := make(map[string]*model, len(tables))
+	for _, table := range tables {
+		m, err := s.model(table)
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %w", table, err)
+		}
+		models = append(models, m)
+		byTable[strings.ToLower(table)] = m
+	}
+	// foreign keys refer to fields of other models
+	for _, m := range models {
+		if err := s.addFKs(m, byTable); err != nil {
+			return nil, fmt.Errorf("table %s: %w", m.table, err)
+		}
+	}
+	src := s.render(models)
+	r, err := format.Source(src)
+	if err != nil {
+		return src, fmt.Errorf("format generated source: %w", err)
+	}
+	return r, nil
+}
+
+// modelNames singular model names of tables. tables which singularize to the same name, eg. status and statuses,
+// keep their plural names
+func (s *generator) modelNames(tables []string) (map[string]string, error) {
+	count := make(map[string]int, len(tables))
+	for _, table := range tables {
+		count[s.naming.SchemaName(table)]++
+	}
+	r := make(map[string]string, len(tables))
+	owners := make(map[string]string, len(tables))
+	for _, table := range tables {
+		name := s.naming.SchemaName(table)
+		if count[name] > 1 {
+			name = schema.NamingStrategy{SingularTable: true}.SchemaName(table)
+		}
+		if owner, ok := owners[name]; ok {
+			return nil, fmt.Errorf("tables %s and %s both map to model %s", owner, table, name)
+		}
+		owners[name] = table
+		r[table] = name
+	}
+	return r, nil
+}
+
+// modelName model name of table, tables out of the generated set take the name of the naming strategy
+func (s *generator) modelName(table string) string {
+	if name, ok := s.names[table]; ok {
+		return name
+	}
+	return s.naming.SchemaName(table)
+}
+
+func (s *generator) model(table string) (*model, error) {
+	columns, err := s.ddl.Columns(table)
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no columns")
+	}
+	m := &model{table: table, name: s.modelName(table), byColumn: make(map[string]*field)}
+	m.tableName = s.naming.TableName(m.name) != table
+	names := make(map[string]bool)
+	for _, c := range columns {
+		f := &field{column: c, name: fieldName(c.Name), goType: goType(s.ddl.Dialect(), c)}
+		for n := 2; names[f.name]; n++ {
+			f.name = fieldName(c.Name) + strconv.Itoa(n)
+		}
+		names[f.name] = true
+		if s.naming.ColumnName(table, f.name) != c.Name {
+			f.tags = append(f.tags, "column:"+c.Name)
+		}
+		f.tags = append(f.tags, "type:"+c.Type)
+		m.usesTime = m.usesTime || strings.HasSuffix(f.goType, "time.Time")
+		m.fields = append(m.fields, f)
+		m.byColumn[strings.ToLower(c.Name)] = f
+	}
+	if err := s.addIndexes(m); err != nil {
+		return nil, err
+	}
+	for _, f := range m.fields {
+		c := f.column
+		isPK := false
+		for _, pk := range m.pk {
+			isPK = isPK || pk == f
+		}
+		switch {
+		case isPK && !c.AutoIncrement && strings.Contains(f.goType, "int"):
+			f.tags = append(f.tags, "autoIncrement:false")
+		case !isPK && !c.Nullable:
+			f.tags = append(f.tags, "not null")
+		}
+		if c.Default != nil && !c.AutoIncrement {
+			f.tags = append(f.tags, "default:"+*c.Default)
+		}
+	}
+	if err := s.addChecks(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// addIndexes primary key, index tags and cache indexes from db indexes. expression indexes are skipped
+func (s *generator) addIndexes(m *model) error {
+	indexes, err := s.ddl.Indexes(m.table)
+	if err != nil {
+		return err
+	}
+	// unique indexes first, a multi cache index on the same fields would conflict
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return indexes[i].Unique && !indexes[j].Unique
+	})
+	fieldsOf := func(idx tablecache.DBIndex) []*field {
+		var r []*field
+		for _, c := range idx.Columns {
+			if f, ok := m.byColumn[strings.ToLower(c)]; ok {
+				r = append(r, f)
+			}
+		}
+		if len(r) != len(idx.Columns) {
+			return nil
+		}
+		return r
+	}
+	for _, idx := range indexes {
+		if idx.Primary {
+			m.pk = fieldsOf(idx)
+			for _, f := range m.pk {
+				f.tags = append(f.tags, "primaryKey")
+			}
+		}
+	}
+	for _, idx := range indexes {
+		fields := fieldsOf(idx)
+		if idx.Primary {
+			continue
+		}
+		if fields == nil {
+			m.comments = append(m.comments, fmt.Sprintf("index %s on expressions is not declared", idx.Name))
+			continue
+		}
+		names := make([]string, len(fields))
+		for i, f := range fields {
+			names[i] = f.name
+			switch {
+			// UNIQUE column constraint, sqlite reserves the index name
+			case strings.HasPrefix(idx.Name, "sqlite_autoindex_") && len(fields) == 1:
+				f.tags = append(f.tags, "unique")
+			case len(fields) == 1 && idx.Unique:
+				f.tags = append(f.tags, "uniqueIndex:"+idx.Name)
+			case len(fields) == 1:
+				f.tags = append(f.tags, "index:"+idx.Name)
+			case idx.Unique:
+				f.tags = append(f.tags, fmt.Sprintf("uniqueIndex:%s,priority:%d", idx.Name, i+1))
+			default:
+				f.tags = append(f.tags, fmt.Sprintf("index:%s,priority:%d", idx.Name, i+1))
+			}
+		}
+		index := tablecache.Multi(names...)
+		if idx.Unique {
+			index = tablecache.Unique(names...)
+		}
+		if s.declaresIndex(m, index) {
+			m.indexes = append(m.indexes, index)
+		}
+	}
+	return nil
+}
+
+// declaresIndex index is not on the id field and conflicts with no declared index
+func (s *generator) declaresIndex(m *model, index tablecache.Index) bool {
+	if len(m.pk) == 1 && len(index.Fields) == 1 && index.Fields[0] == m.pk[0].name {
+		return false
+	}
+	for _, v := range m.indexes {
+		if v.Match(index.Fields) {
+			return false
+		}
+	}
+	return true
+}
+
+// addChecks check tag on the first field the expression mentions
+func (s *generator) addChecks(m *model) error {
+	checks, err := s.ddl.Checks(m.table)
+	if err != nil {
+		return err
+	}
+	for _, chk := range checks {
+		expr := strings.ReplaceAll(chk.Expr, "`", "")
+		var target *field
+		for _, f := range m.fields {
+			if regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(f.column.Name) + `\b`).MatchString(expr) {
+				target = f
+				break
+			}
+		}
+		if target == nil || !checkNameRe.MatchString(chk.Name) {
+			m.comments = append(m.comments, fmt.Sprintf("CONSTRAINT %s CHECK (%s)", chk.Name, expr))
+			continue
+		}
+		target.tags = append(target.tags, "check:"+chk.Name+","+expr)
+	}
+	return nil
+}
+
+// addFKs FK tags which DDL.MakeFKs understands, with explicit actions. composite foreign keys are named on each field.
+// byTable are generated models by lower case table name
+func (s *generator) addFKs(m *model, byTable map[string]*model) error {
+	fks, err := s.ddl.ForeignKeys(m.table)
+	if err != nil {
+		return err
+	}
+	for _, fk := range fks {
+		refColumns := fk.RefColumns
+		refPK, err := s.primaryColumns(fk.RefTable)
+		if err != nil {
+			return err
+		}
+		// sqlite references primary key implicitly
+		if len(refColumns) == 0 {
+			refColumns = refPK
+		}
+		var fields []*field
+		for _, c := range fk.Columns {
+			if f, ok := m.byColumn[strings.ToLower(c)]; ok {
+				fields = append(fields, f)
+			}
+		}
+		taken := false
+		for _, f := range fields {
+			for _, t := range f.tags {
+				taken = taken || strings.HasPrefix(t, "FK:")
+			}
+		}
+		if len(fields) != len(fk.Columns) || len(refColumns) != len(fk.Columns) || taken {
+			m.comments = append(m.comments, fmt.Sprintf("CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s) is not declared",
+				fk.Name, strings.Join(fk.Columns, ", "), fk.RefTable, strings.Join(refColumns, ", ")))
+			continue
+		}
+		refModel := byTable[strings.ToLower(fk.RefTable)]
+		refStruct := s.modelName(fk.RefTable)
+		if refModel != nil {
+			refStruct = refModel.name
+		}
+		// unnamed sqlite foreign keys take the default name, composite ones need a name to group fields
+		defaultName := s.ddl.MakeFKName(m.table, fk.Columns[0], fk.RefTable, refColumns[0])
+		name := fk.Name
+		if name == "" {
+			name = defaultName
+		}
+		actions := fmt.Sprintf("onDelete=%s,onUpdate=%s", orNoAction(fk.OnDelete), orNoAction(fk.OnUpdate))
+		for i, f := range fields {
+			tag := "FK:" + refStruct
+			if len(fields) > 1 || len(refPK) != 1 || !strings.EqualFold(refColumns[i], refPK[0]) {
+				refField := fieldName(refColumns[i])
+				// generated names may carry a suffix against collisions
+				if refModel != nil {
+					if f, ok := refModel.byColumn[strings.ToLower(refColumns[i])]; ok {
+						refField = f.name
+					}
+				}
+				tag += "." + refField
+			}
+			tag += "," + actions
+			if fk.Name != "" && fk.Name != defaultName || len(fields) > 1 {
+				tag += ",name=" + name
+			}
+			f.tags = append(f.tags, tag)
+		}
+	}
+	return nil
+}
+
+func orNoAction(action tablecache.FKAction) tablecache.FKAction {
+	if action == tablecache.FKEmpty {
+		return tablecache.FKNoAction
+	}
+	return action
+}
+
+func (s *generator) primaryColumns(table string) ([]string, error) {
+	indexes, err := s.ddl.Indexes(table)
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range indexes {
+		if idx.Primary {
+			return idx.Columns, nil
+		}
+	}
+	return nil, nil
+}
+
+// gormTag `gorm:"a;b"`, semicolons in values are escaped, backticks can not be in raw strings
+func gormTag(tags []string) string {
+	for i, t := range tags {
+		tags[i] = strings.ReplaceAll(t, ";", `\;`)
+	}
+	return "`gorm:" + strconv.Quote(strings.Join(tags, ";")) + "`"
+}
+
+func (s *generator) render(models []*model) []byte {
+	var b strings.Builder
+	usesTime, caches := false, false
+	for _, m := range models {
+		usesTime = usesTime || m.usesTime
+		caches = caches || len(m.pk) == 1
+	}
+	fmt.Fprintf(&b, "// Code generated by tablecache-gen from %s database. DO NOT EDIT.\n\n", s.ddl.Dialect())
+	fmt.Fprintf(&b, "package %s\n\n", s.pkg)
+	b.WriteString("import (\n")
+	if usesTime || caches {
+		b.WriteString("\t\"time\"\n\n")
+	}
+	if caches {
+		b.WriteString("\t\"github.com/daqiancode/tablecache\"\n\t\"github.com/go-redis/redis/v8\"\n\t\"gorm.io/gorm\"\n")
+	}
+	b.WriteString(")\n\n")
+	for _, m := range models {
+		fmt.Fprintf(&b, "// %s table %s\n", m.name, m.table)
+		for _, c := range m.comments {
+			fmt.Fprintf(&b, "// %s\n", c)
+		}
+		fmt.Fprintf(&b, "type %s struct {\n", m.name)
+		for _, f := range m.fields {
+			fmt.Fprintf(&b, "\t%s %s %s\n", f.name, f.goType, gormTag(f.tags))
+		}
+		b.WriteString("}\n\n")
+		if m.tableName {
+			fmt.Fprintf(&b, "func (%s) TableName() string {\n\treturn %q\n}\n\n", m.name, m.table)
+		}
+		if len(m.pk) != 1 {
+			fmt.Fprintf(&b, "// %s has no single primary key, TableCache needs one id field\n\n", m.table)
+			continue
+		}
+		indexes := make([]string, len(m.indexes))
+		for i, idx := range m.indexes {
+			fields := make([]string, len(idx.Fields))
+			for j, f := range idx.Fields {
+				fields[j] = strconv.Quote(f)
+			}
+			kind := "Multi"
+			if idx.IsUnique() {
+				kind = "Unique"
+			}
+			indexes[i] = fmt.Sprintf("tablecache.%s(%s)", kind, strings.Join(fields, ", "))
+		}
+		fmt.Fprintf(&b, "// New%sCache TableCache of %s\n", m.name, m.table)
+		fmt.Fprintf(&b, "func New%sCache(redisClient *redis.Client, db *gorm.DB, ttl time.Duration, prefix string) *tablecache.TableCache {\n", m.name)
+		fmt.Fprintf(&b, "\treturn tablecache.NewTableCache(tablecache.NewRedisGorm(redisClient, db, ttl, %q, prefix,\n", m.pk[0].name)
+		fmt.Fprintf(&b, "\t\tfunc() interface{} { return &%s{} }, func() interface{} { return &[]%s{} }), %q,\n", m.name, m.name, m.name)
+		if len(indexes) == 0 {
+			b.WriteString("\t\tnil)\n}\n\n")
+		} else {
+			fmt.Fprintf(&b, "\t\t[]tablecache.Index{%s})\n}\n\n", strings.Join(indexes, ", "))
+		}
+	}
+	b.WriteString("// Models all generated models, eg. for DDL.AddTables\nfunc Models() []interface{} {\n\treturn []interface{}{")
+	for i, m := range models {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "&%s{}", m.name)
+	}
+	b.WriteString("}\n}\n")
+	return []byte(b.String())
+}
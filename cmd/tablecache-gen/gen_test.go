@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daqiancode/tablecache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+func TestFieldName(t *testing.T) {
+	cases := []struct {
+		column, name string
+	}{
+		{"id", "ID"},
+		{"project_id", "ProjectID"},
+		{"created_at", "CreatedAt"},
+		{"status", "Status"},
+		{"user-name", "UserName"},
+		{"2fa", "F2fa"},
+		{"_", "F"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.name, fieldName(c.column), c.column)
+	}
+}
+
+func TestGoType(t *testing.T) {
+	cases := []struct {
+		dialect  string
+		typ      string
+		nullable bool
+		goType   string
+	}{
+		{tablecache.DialectSQLite, "integer", false, "int64"},
+		{tablecache.DialectSQLite, "INTEGER", true, "*int64"},
+		{tablecache.DialectMySQL, "integer", false, "int32"},
+		{tablecache.DialectMySQL, "int unsigned", false, "uint32"},
+		{tablecache.DialectMySQL, "bigint unsigned", false, "uint64"},
+		{tablecache.DialectMySQL, "tinyint(1)", false, "bool"},
+		{tablecache.DialectMySQL, "tinyint(4)", false, "int8"},
+		{tablecache.DialectPostgres, "boolean", true, "*bool"},
+		{tablecache.DialectPostgres, "real", false, "float32"},
+		{tablecache.DialectMySQL, "real", false, "float64"},
+		{tablecache.DialectSQLite, "real", false, "float64"},
+		{tablecache.DialectMySQL, "decimal(10,2)", true, "*float64"},
+		{tablecache.DialectMySQL, "datetime(3)", false, "time.Time"},
+		{tablecache.DialectPostgres, "timestamp with time zone", true, "*time.Time"},
+		{tablecache.DialectMySQL, "varchar(100)", true, "*string"},
+		{tablecache.DialectPostgres, "bytea", true, "[]byte"},
+		{tablecache.DialectMySQL, "longblob", false, "[]byte"},
+	}
+	for _, c := range cases {
+		got := goType(c.dialect, tablecache.DBColumn{Name: "c", Type: c.typ, Nullable: c.nullable})
+		assert.Equal(t, c.goType, got, "%s %s nullable=%v", c.dialect, c.typ, c.nullable)
+	}
+}
+
+func TestGormTag(t *testing.T) {
+	cases := []struct {
+		tags []string
+		tag  string
+	}{
+		{[]string{"primaryKey"}, "`gorm:\"primaryKey\"`"},
+		{[]string{"type:varchar(100)", "not null"}, "`gorm:\"type:varchar(100);not null\"`"},
+		{[]string{"default:'a;b'"}, "`gorm:\"default:'a\\\\;b'\"`"},
+		{[]string{`check:chk_name,name <> "x"`}, "`gorm:\"check:chk_name,name <> \\\"x\\\"\"`"},
+	}
+	for _, c := range cases {
+		tag := gormTag(append([]string(nil), c.tags...))
+		assert.Equal(t, c.tag, tag)
+		// gorm reads back the original settings
+		value := strings.TrimSuffix(strings.TrimPrefix(tag, "`"), "`")
+		settings := schema.ParseTagSetting(reflect.StructTag(value).Get("gorm"), ";")
+		for _, v := range c.tags {
+			key := strings.ToUpper(strings.SplitN(v, ":", 2)[0])
+			assert.Contains(t, settings, key, v)
+		}
+	}
+}
+
+func TestDeclaresIndex(t *testing.T) {
+	id := &field{name: "ID"}
+	cases := []struct {
+		name     string
+		pk       []*field
+		declared []tablecache.Index
+		index    tablecache.Index
+		ok       bool
+	}{
+		{"id field", []*field{id}, nil, tablecache.Unique("ID"), false},
+		{"plain", []*field{id}, nil, tablecache.Multi("Name"), true},
+		{"composite pk part", []*field{id, {name: "TenantID"}}, nil, tablecache.Multi("ID"), true},
+		{"same fields other order", []*field{id}, []tablecache.Index{tablecache.Unique("A", "B")}, tablecache.Multi("b", "a"), false},
+		{"subset", []*field{id}, []tablecache.Index{tablecache.Unique("A", "B")}, tablecache.Multi("A"), true},
+	}
+	g := &generator{}
+	for _, c := range cases {
+		m := &model{pk: c.pk, indexes: c.declared}
+		assert.Equal(t, c.ok, g.declaresIndex(m, c.index), c.name)
+	}
+}
+
+func TestModelNames(t *testing.T) {
+	g := &generator{naming: schema.NamingStrategy{}}
+	names, err := g.modelNames([]string{"status", "statuses", "user", "users", "projects"})
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{"status": "Status", "statuses": "Statuses", "user": "User", "users": "Users", "projects": "Project"}, names)
+	_, err = g.modelNames([]string{"user", "User"})
+	assert.NotNil(t, err)
+}
+
+type Base struct {
+	ID        uint64    `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"type:datetime not null;"`
+	UpdatedAt time.Time `gorm:"type:datetime not null;"`
+}
+
+type Team struct {
+	Base
+	Name string `gorm:"type:varchar(100) not null;"`
+}
+
+type Member struct {
+	Base
+	TeamID uint64 `gorm:"not null;FK:Team"`
+	Team   *Team
+}
+
+type Account struct {
+	Base
+	Email string `gorm:"type:varchar(100) not null;uniqueIndex"`
+	Age   int    `gorm:"check:chk_accounts_age,age >= 0"`
+}
+
+type Post struct {
+	Base
+	AuthorEmail *string `gorm:"type:varchar(100);FK:Account.Email,onDelete=SET NULL,name=fk_posts_author"`
+}
+
+// roundTripMain prints foreign keys declared by the generated models
+const roundTripMain = `package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/daqiancode/tablecache"
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func main() {
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		panic(err)
+	}
+	ddl := tablecache.NewDDL(db)
+	if err := ddl.AddTables(Models()...); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fks, err := ddl.DeclaredFKs()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	json.NewEncoder(os.Stdout).Encode(fks)
+}
+`
+
+// TestGenerateRoundTrip generate models from tables made by FK tags, the generated tags declare the same foreign keys
+func TestGenerateRoundTrip(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds generated models")
+	}
+	db, err := gorm.Open(sqlite.Open("file:roundtrip?mode=memory&cache=shared&_foreign_keys=1"),
+		&gorm.Config{DisableForeignKeyConstraintWhenMigrating: true})
+	require.Nil(t, err)
+	tables := []interface{}{&Team{}, &Member{}, &Account{}, &Post{}}
+	require.Nil(t, db.AutoMigrate(tables...))
+	ddl := tablecache.NewDDL(db)
+	require.Nil(t, ddl.AddTables(tables...))
+	require.Nil(t, ddl.MakeFKs())
+	declared, err := ddl.DeclaredFKs()
+	require.Nil(t, err)
+	require.Len(t, declared, 2)
+
+	g := &generator{ddl: tablecache.NewDDL(db), naming: db.NamingStrategy, pkg: "main"}
+	src, err := g.Generate([]string{"teams", "members", "accounts", "posts"})
+	require.Nil(t, err, string(src))
+	assert.Regexp(t, regexp.MustCompile(`FK:Account\.Email,onDelete=SET NULL,onUpdate=CASCADE,name=fk_posts_author`), string(src))
+
+	dir := t.TempDir()
+	root, err := filepath.Abs("../..")
+	require.Nil(t, err)
+	mod, err := os.ReadFile("go.mod")
+	require.Nil(t, err)
+	gomod := strings.Replace(string(mod), "module github.com/daqiancode/tablecache/cmd/tablecache-gen", "module roundtrip", 1)
+	gomod = strings.Replace(gomod, "=> ../..", "=> "+root, 1)
+	sum, err := os.ReadFile("go.sum")
+	require.Nil(t, err)
+	for name, content := range map[string][]byte{"go.mod": []byte(gomod), "go.sum": sum, "models.go": src, "main.go": []byte(roundTripMain)} {
+		require.Nil(t, os.WriteFile(filepath.Join(dir, name), content, 0644))
+	}
+	cmd := exec.Command("go", "run", "-mod=mod", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=", "GOWORK=off")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	require.Nil(t, err, stderr.String())
+	var generated []tablecache.ForeignKey
+	require.Nil(t, json.Unmarshal(out, &generated))
+
+	require.Len(t, generated, len(declared))
+	for i, want := range declared {
+		got := generated[i]
+		assert.Equal(t, want.Name, got.Name)
+		assert.Equal(t, want.Table, got.Table)
+		assert.Equal(t, want.Columns, got.Columns)
+		assert.Equal(t, want.RefTable, got.RefTable)
+		assert.Equal(t, want.RefColumns, got.RefColumns)
+		assert.Equal(t, want.OnDelete, got.OnDelete)
+		assert.Equal(t, want.OnUpdate, got.OnUpdate)
+	}
+}
@@ -0,0 +1,81 @@
+// tablecache-gen generate gorm models with FK tags and TableCache constructors from an existing database. eg.
+//	tablecache-gen -dialect mysql -dsn "user:pass@tcp(127.0.0.1:3306)/app?parseTime=true" -pkg models -out models/tables.go
+//	tablecache-gen -dialect postgres -dsn "host=localhost user=app dbname=app" -tables users,projects
+//	tablecache-gen -dialect sqlite -dsn app.db
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/daqiancode/tablecache"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func main() {
+	dialect := flag.String("dialect", tablecache.DialectMySQL, "mysql, postgres or sqlite")
+	dsn := flag.String("dsn", "", "data source name of database")
+	tables := flag.String("tables", "", "comma separated tables, all tables of database if empty")
+	pkg := flag.String("pkg", "models", "package name of generated file")
+	out := flag.String("out", "", "output file, stdout if empty")
+	flag.Parse()
+	if *dsn == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(*dialect, *dsn, *tables, *pkg, *out); err != nil {
+		fmt.Fprintln(os.Stderr, "tablecache-gen:", err)
+		os.Exit(1)
+	}
+}
+
+func open(dialect, dsn string) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch dialect {
+	case tablecache.DialectMySQL:
+		dialector = mysql.Open(dsn)
+	case tablecache.DialectPostgres:
+		dialector = postgres.Open(dsn)
+	case tablecache.DialectSQLite:
+		dialector = sqlite.Open(dsn)
+	default:
+		return nil, fmt.Errorf("%w: %s", tablecache.ErrDialectUnsupported, dialect)
+	}
+	return gorm.Open(dialector, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+}
+
+func run(dialect, dsn, tables, pkg, out string) error {
+	db, err := open(dialect, dsn)
+	if err != nil {
+		return err
+	}
+	g := &generator{ddl: tablecache.NewDDL(db), naming: db.NamingStrategy, pkg: pkg}
+	var names []string
+	if tables != "" {
+		for _, t := range strings.Split(tables, ",") {
+			names = append(names, strings.TrimSpace(t))
+		}
+	}
+	src, err := g.Generate(names)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	_, err = w.Write(src)
+	return err
+}
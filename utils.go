@@ -120,6 +120,21 @@ func (s *CacheUtil) Stringify(value interface{}) string {
 	return fmt.Sprintf("%v", value)
 }
 
+// StringifyDeref Stringify pointed value, nil -> null
+func (s *CacheUtil) StringifyDeref(value interface{}) string {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return NullStr
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return NullStr
+	}
+	return s.Stringify(v.Interface())
+}
+
 // Get field from
 func (s *CacheUtil) GetFieldValue(structValue interface{}, field string) interface{} {
 	return reflect.Indirect(reflect.ValueOf(structValue)).FieldByName(field).Interface()
@@ -170,6 +185,20 @@ func ToStringSlice(a interface{}) []string {
 	return r
 }
 
+// toInterfaces slice of any type -> []interface{}
+func toInterfaces(a interface{}) []interface{} {
+	if r, ok := a.([]interface{}); ok {
+		return r
+	}
+	aV := reflect.Indirect(reflect.ValueOf(a))
+	n := aV.Len()
+	r := make([]interface{}, n)
+	for i := 0; i < n; i++ {
+		r[i] = aV.Index(i).Interface()
+	}
+	return r
+}
+
 func isSlice(value interface{}) bool {
 	return reflect.Indirect(reflect.ValueOf(value)).Kind() == reflect.Slice
 }